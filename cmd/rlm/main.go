@@ -1,18 +1,24 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
-	"time"
+	"unicode/utf8"
 
 	"github.com/Brainqub3/claude_code_RLM/internal/rlmchunk"
 	"github.com/Brainqub3/claude_code_RLM/internal/rlmconfig"
 	"github.com/Brainqub3/claude_code_RLM/internal/rlmfiles"
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmfilter"
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmindex"
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmpeek"
 	"github.com/Brainqub3/claude_code_RLM/internal/rlmsearch"
 )
 
@@ -57,6 +63,8 @@ func run(argv []string) int {
 		return cmdPeek(args)
 	case "chunk":
 		return cmdChunk(args)
+	case "index":
+		return cmdIndex(args)
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", cmd)
 		printUsage()
@@ -75,8 +83,9 @@ Commands:
   config   Show/set configuration (global or per-workspace)
   files    List files in the configured context directory
   search   Search for a string/regex across context files
-  peek     Extract a byte range from a file
+  peek     Extract a byte or line range from a file
   chunk    Write fixed-size chunks of a file to disk
+  index    Build a search index, or a line index for one file
 
 Environment:
   RLM_CONTEXT_DIR  Overrides configured context directory
@@ -269,10 +278,15 @@ func cmdFiles(argv []string) int {
 	fs := flag.NewFlagSet("rlm files", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
 	dirFlag := fs.String("dir", "", "Override context directory")
+	filter := filterFlags(fs)
 	jsonOut := fs.Bool("json", false, "Output JSON")
 	if err := fs.Parse(argv); err != nil {
 		return 2
 	}
+	if err := filter.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
 
 	wsRoot, err := rlmconfig.DetectWorkspaceRoot("")
 	if err != nil {
@@ -286,7 +300,7 @@ func cmdFiles(argv []string) int {
 		return 2
 	}
 
-	files, err := rlmfiles.List(resolved.ContextDir)
+	files, err := rlmfiles.List(resolved.ContextDir, *filter)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		return 2
@@ -315,10 +329,46 @@ func cmdSearch(argv []string) int {
 	ignoreCase := fs.Bool("ignore-case", false, "Case-insensitive matching")
 	maxMatches := fs.Int("max-matches", 50, "Maximum total matches")
 	maxPerFile := fs.Int("max-per-file", 20, "Maximum matches per file")
+	noIndex := fs.Bool("no-index", false, "Ignore the search index and scan every file")
+	workers := fs.Int("workers", 0, "Files searched concurrently (0 = one per CPU)")
+	allPerLine := fs.Bool("all-per-line", false, "Report every occurrence on a line, not just the first")
+	countAll := fs.Bool("count-all", false, "Keep scanning past the limits to report total_matches")
+	filter := filterFlags(fs)
+	var before, after, contextLines int
+	fs.IntVar(&before, "before", 0, "Lines of context before each match")
+	fs.IntVar(&before, "B", 0, "Shorthand for --before")
+	fs.IntVar(&after, "after", 0, "Lines of context after each match")
+	fs.IntVar(&after, "A", 0, "Shorthand for --after")
+	fs.IntVar(&contextLines, "context", 0, "Lines of context before and after each match")
+	fs.IntVar(&contextLines, "C", 0, "Shorthand for --context")
 	jsonOut := fs.Bool("json", true, "Output JSON")
+	format := fs.String("format", "", "Output format: json|ndjson|text (default: json, or text with --json=false)")
 	if err := fs.Parse(argv); err != nil {
 		return 2
 	}
+	outFormat := *format
+	switch outFormat {
+	case "":
+		outFormat = "json"
+		if !*jsonOut {
+			outFormat = "text"
+		}
+	case "json", "ndjson", "text":
+	default:
+		fmt.Fprintln(os.Stderr, "--format must be json, ndjson or text")
+		return 2
+	}
+	if before < 0 || after < 0 || contextLines < 0 {
+		fmt.Fprintln(os.Stderr, "--before, --after and --context must be >= 0")
+		return 2
+	}
+	// Explicit --before/--after take precedence over --context.
+	if before == 0 {
+		before = contextLines
+	}
+	if after == 0 {
+		after = contextLines
+	}
 
 	q := strings.TrimSpace(*query)
 	if q == "" {
@@ -346,23 +396,61 @@ func cmdSearch(argv []string) int {
 		return 2
 	}
 
-	start := time.Now()
-	result, err := rlmsearch.SearchDir(rlmsearch.Options{
-		ContextDir:   resolved.ContextDir,
-		Query:        q,
-		Regex:        *regex,
-		IgnoreCase:   *ignoreCase,
-		MaxMatches:   *maxMatches,
-		MaxPerFile:   *maxPerFile,
-		MaxLineChars: 800,
-	})
+	indexDir, linesDir := "", ""
+	if !*noIndex {
+		indexDir, linesDir = indexDirFor(wsRoot), linesDirFor(wsRoot)
+	}
+
+	opts := rlmsearch.Options{
+		ContextDir:     resolved.ContextDir,
+		Query:          q,
+		Regex:          *regex,
+		IgnoreCase:     *ignoreCase,
+		MaxMatches:     *maxMatches,
+		MaxPerFile:     *maxPerFile,
+		MaxLineChars:   800,
+		IndexDir:       indexDir,
+		LinesDir:       linesDir,
+		Workers:        *workers,
+		Before:         before,
+		After:          after,
+		AllOccurrences: *allPerLine,
+		Filter:         *filter,
+		CountAll:       *countAll,
+	}
+
+	if outFormat == "ndjson" {
+		// One record per match as it is found, then a summary record.
+		enc := json.NewEncoder(os.Stdout)
+		found := 0
+		summary, err := rlmsearch.Search(opts, func(m rlmsearch.Match) error {
+			found++
+			return enc.Encode(struct {
+				Type string `json:"type"`
+				rlmsearch.Match
+			}{"match", m})
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+		_ = enc.Encode(struct {
+			Type string `json:"type"`
+			rlmsearch.Summary
+		}{"summary", summary})
+		if found == 0 {
+			return 1
+		}
+		return 0
+	}
+
+	result, err := rlmsearch.SearchDir(opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		return 2
 	}
-	result.DurationMs = time.Since(start).Milliseconds()
 
-	if *jsonOut {
+	if outFormat == "json" {
 		enc := json.NewEncoder(os.Stdout)
 		enc.SetIndent("", "  ")
 		_ = enc.Encode(result)
@@ -373,7 +461,17 @@ func cmdSearch(argv []string) int {
 	}
 
 	for _, m := range result.Matches {
+		// Context lines are contiguous with the match, grep-style.
+		for i, l := range m.ContextBefore {
+			fmt.Printf("%s-%d-%s\n", m.Path, m.Line-len(m.ContextBefore)+i, l)
+		}
 		fmt.Printf("%s:%d:%s\n", m.Path, m.Line, m.Snippet)
+		for i, l := range m.ContextAfter {
+			fmt.Printf("%s-%d-%s\n", m.Path, m.Line+1+i, l)
+		}
+	}
+	if result.Truncated {
+		fmt.Fprintf(os.Stderr, "results truncated (%s)\n", strings.Join(result.LimitsHit, ", "))
 	}
 	if len(result.Matches) == 0 {
 		return 1
@@ -387,6 +485,20 @@ func cmdPeek(argv []string) int {
 	dirFlag := fs.String("dir", "", "Override context directory")
 	start := fs.Int64("start", 0, "Start byte offset")
 	end := fs.Int64("end", 0, "End byte offset (exclusive). Use -1 for EOF")
+	around := fs.Int64("around", -1, "Center byte offset (e.g. a search match's byte_offset)")
+	radius := fs.Int64("radius", 1024, "Bytes on each side of --around, or lines on each side of --line (default 10 lines)")
+	lines := fs.String("lines", "", "Line range FROM:TO, 1-based and inclusive (omit TO for EOF)")
+	line := fs.Int("line", 0, "Center line (1-based); see --radius")
+	fromRegex := fs.String("from-regex", "", "Start at a match of this regex (see --occurrence)")
+	toRegex := fs.String("to-regex", "", "End before the first match of this regex after the --from-regex match")
+	occurrence := fs.Int("occurrence", 1, "Which match of --from-regex to start at (1-based)")
+	maxBytes := fs.Int64("max-bytes", 0, "Cap the length of a --from-regex range (0 = no limit)")
+	raw := fs.Bool("raw", false, "Use exact byte offsets instead of moving them to UTF-8 rune starts")
+	noIndex := fs.Bool("no-index", false, "Do not use or update the line index")
+	rangesFile := fs.String("ranges", "", "JSON array of {path, start, end} or {path, start_line, end_line} ranges to read in one go (- for stdin)")
+	var rangeArgs stringList
+	fs.Var(&rangeArgs, "range", "Range FILE:START:END in bytes, or FILE:LFROM:TO in lines; an empty end means EOF (repeatable)")
+	format := fs.String("format", "text", "Payload format: text, hex (xxd-style dump) or base64; hex and base64 use exact byte offsets")
 	jsonOut := fs.Bool("json", false, "Output JSON")
 	argv = normalizeAndReorderArgs(fs, argv)
 	if err := fs.Parse(argv); err != nil {
@@ -394,15 +506,106 @@ func cmdPeek(argv []string) int {
 	}
 
 	args := fs.Args()
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	switch *format {
+	case "text":
+	case "hex", "base64":
+		// Binary views show the bytes exactly as requested.
+		*raw = true
+	default:
+		fmt.Fprintln(os.Stderr, "--format must be text, hex or base64")
+		return 2
+	}
+	if *rangesFile != "" || len(rangeArgs) > 0 {
+		if *format != "text" {
+			fmt.Fprintln(os.Stderr, "--format hex|base64 cannot be combined with --ranges/--range")
+			return 2
+		}
+		for _, name := range []string{"start", "end", "around", "lines", "line", "from-regex"} {
+			if set[name] {
+				fmt.Fprintf(os.Stderr, "--%s cannot be combined with --ranges/--range\n", name)
+				return 2
+			}
+		}
+		if len(args) != 0 {
+			fmt.Fprintln(os.Stderr, "Usage: rlm peek --ranges FILE | --range FILE:START:END ...")
+			return 2
+		}
+		return peekBatch(*dirFlag, *rangesFile, rangeArgs, *raw, *noIndex)
+	}
 	if len(args) != 1 {
-		fmt.Fprintln(os.Stderr, "Usage: rlm peek <file> --start N [--end M] (M=-1 for EOF)")
+		fmt.Fprintln(os.Stderr, "Usage: rlm peek <file> --start N [--end M] (M=-1 for EOF) | --around N [--radius R] | --lines FROM:TO | --line N [--radius K]")
+		fmt.Fprintln(os.Stderr, "       rlm peek <file> --from-regex RE [--to-regex RE --occurrence N --max-bytes N]")
+		fmt.Fprintln(os.Stderr, "       rlm peek --ranges FILE | --range FILE:START:END ...")
+		return 2
+	}
+	modes := 0
+	for _, m := range []bool{set["start"] || set["end"], *around >= 0, *lines != "", *line != 0, *fromRegex != ""} {
+		if m {
+			modes++
+		}
+	}
+	if modes > 1 {
+		fmt.Fprintln(os.Stderr, "--start/--end, --around, --lines, --line and --from-regex cannot be combined")
+		return 2
+	}
+	var anchors rlmpeek.AnchorOptions
+	if *fromRegex != "" {
+		if *occurrence < 1 {
+			fmt.Fprintln(os.Stderr, "--occurrence must be >= 1")
+			return 2
+		}
+		if *maxBytes < 0 {
+			fmt.Fprintln(os.Stderr, "--max-bytes must be >= 0")
+			return 2
+		}
+		var err error
+		if anchors.From, err = regexp.Compile(*fromRegex); err != nil {
+			fmt.Fprintf(os.Stderr, "--from-regex: %v\n", err)
+			return 2
+		}
+		if *toRegex != "" {
+			if anchors.To, err = regexp.Compile(*toRegex); err != nil {
+				fmt.Fprintf(os.Stderr, "--to-regex: %v\n", err)
+				return 2
+			}
+		}
+		anchors.Occurrence, anchors.MaxBytes = *occurrence, *maxBytes
+	} else if set["to-regex"] || set["occurrence"] || set["max-bytes"] {
+		fmt.Fprintln(os.Stderr, "--to-regex, --occurrence and --max-bytes require --from-regex")
+		return 2
+	}
+	if *radius < 0 {
+		fmt.Fprintln(os.Stderr, "--radius must be >= 0")
 		return 2
 	}
-	if *end < *start {
+	if *end > 0 && *end < *start {
 		fmt.Fprintln(os.Stderr, "--end must be >= --start")
 		return 2
 	}
 
+	// Line modes resolve to a line range first.
+	fromLine, toLine := 0, 0
+	switch {
+	case *lines != "":
+		var err error
+		if fromLine, toLine, err = parseLineRange(*lines); err != nil {
+			fmt.Fprintf(os.Stderr, "--lines: %v\n", err)
+			return 2
+		}
+	case *line != 0:
+		if *line < 0 {
+			fmt.Fprintln(os.Stderr, "--line must be >= 1")
+			return 2
+		}
+		k := 10
+		if set["radius"] {
+			k = int(*radius)
+		}
+		fromLine, toLine = max(*line-k, 1), *line+k
+	}
+
 	wsRoot, err := rlmconfig.DetectWorkspaceRoot("")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
@@ -430,23 +633,75 @@ func cmdPeek(argv []string) int {
 
 	s := *start
 	e := *end
-	if s < 0 {
-		s = 0
-	}
-	if e < 0 {
-		e = st.Size()
-	} else if e == 0 {
-		const defaultPeekBytes = int64(8192)
-		e = s + defaultPeekBytes
-		if e > st.Size() {
+	// The line index answers line lookups without reading the file. It is
+	// built or refreshed for line ranges, and used for byte ranges when
+	// it is already current.
+	var lineIx *rlmindex.Lines
+	if !*noIndex {
+		if fromLine > 0 {
+			lineIx, _, _ = rlmindex.EnsureLines(linesDirFor(wsRoot), p)
+		} else if *jsonOut {
+			lineIx, _ = rlmindex.OpenLines(linesDirFor(wsRoot), p)
+		}
+		if lineIx != nil {
+			defer lineIx.Close()
+		}
+	}
+
+	var lineRange *rlmpeek.Range
+	var anchored *rlmpeek.Anchored
+	if anchors.From != nil {
+		a, err := rlmpeek.FindAnchors(io.NewSectionReader(f, 0, st.Size()), anchors)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+		anchored = &a
+		s, e = a.Start, a.End
+	} else if fromLine > 0 {
+		var rg rlmpeek.Range
+		if lineIx != nil {
+			rg, err = rlmpeek.IndexedLineRange(lineIx, fromLine, toLine)
+		} else {
+			rg, err = rlmpeek.LineRange(f, fromLine, toLine)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+		lineRange = &rg
+		s, e = rg.Start, rg.End
+	} else if *around >= 0 {
+		s = max(*around-*radius, 0)
+		e = min(*around+*radius, st.Size())
+	} else {
+		if s < 0 {
+			s = 0
+		}
+		if e < 0 {
+			e = st.Size()
+		} else if e == 0 {
+			e = s + rlmpeek.DefaultBytes
+			if e > st.Size() {
+				e = st.Size()
+			}
+		} else if e > st.Size() {
 			e = st.Size()
 		}
-	} else if e > st.Size() {
-		e = st.Size()
 	}
 	if e < s {
 		e = s
 	}
+	reqStart, reqEnd := s, e
+	if !*raw {
+		if s, err = rlmchunk.RuneStart(f, s, st.Size()); err == nil {
+			e, err = rlmchunk.RuneStart(f, e, st.Size())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+	}
 
 	if _, err := f.Seek(s, io.SeekStart); err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
@@ -459,42 +714,241 @@ func cmdPeek(argv []string) int {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		return 2
 	}
-	out := string(buf)
 	if *jsonOut {
 		enc := json.NewEncoder(os.Stdout)
 		enc.SetIndent("", "  ")
-		_ = enc.Encode(map[string]any{"path": p, "start": s, "end": e, "text": out})
+		// Invalid UTF-8 would be mangled in "text"; valid_utf8 tells when
+		// hex or base64 should be used instead.
+		res := map[string]any{"path": p, "start": s, "end": e, "valid_utf8": utf8.Valid(buf)}
+		switch *format {
+		case "hex":
+			res["hex"] = rlmpeek.HexDump(buf, s)
+		case "base64":
+			res["base64"] = base64.StdEncoding.EncodeToString(buf)
+		default:
+			res["text"] = string(buf)
+		}
+		if lineRange == nil && lineIx != nil {
+			if rg, err := lineSpan(lineIx, s, e); err == nil {
+				lineRange = &rg
+			}
+		}
+		if lineRange != nil {
+			res["start_line"] = lineRange.StartLine
+			res["end_line"] = lineRange.EndLine
+		}
+		if anchored != nil {
+			res["from_end"] = anchored.FromEnd
+			if anchors.To != nil {
+				res["to_found"] = anchored.ToFound
+			}
+			res["truncated"] = anchored.Truncated
+		}
+		if s != reqStart || e != reqEnd {
+			res["requested_start"] = reqStart
+			res["requested_end"] = reqEnd
+		}
+		_ = enc.Encode(res)
 		return 0
 	}
-	fmt.Print(out)
+	switch *format {
+	case "hex":
+		fmt.Print(rlmpeek.HexDump(buf, s))
+	case "base64":
+		fmt.Println(base64.StdEncoding.EncodeToString(buf))
+	default:
+		_, _ = os.Stdout.Write(buf)
+	}
+	return 0
+}
+
+// peekBatch reads the ranges of a --ranges file and --range flags and
+// prints them as one JSON array, in request order. Relative paths are
+// resolved against the context directory.
+func peekBatch(dirFlag, rangesFile string, rangeArgs []string, raw, noIndex bool) int {
+	var reqs []rlmpeek.Request
+	if rangesFile != "" {
+		var b []byte
+		var err error
+		if rangesFile == "-" {
+			b, err = io.ReadAll(os.Stdin)
+		} else {
+			b, err = os.ReadFile(rangesFile)
+		}
+		if err == nil {
+			err = json.Unmarshal(b, &reqs)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: --ranges: %v\n", err)
+			return 2
+		}
+	}
+	for _, v := range rangeArgs {
+		r, err := parseRangeArg(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--range: %v\n", err)
+			return 2
+		}
+		reqs = append(reqs, r)
+	}
+	for i, r := range reqs {
+		if r.Path == "" {
+			fmt.Fprintf(os.Stderr, "ERROR: range %d has no path\n", i)
+			return 2
+		}
+	}
+
+	wsRoot, err := rlmconfig.DetectWorkspaceRoot("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
+	resolved, err := rlmconfig.Resolve(rlmconfig.ResolveOptions{WorkspaceRoot: wsRoot, DirFlag: dirFlag})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
+	for i := range reqs {
+		reqs[i].Path = resolveFileArg(resolved.ContextDir, reqs[i].Path)
+	}
+
+	opts := rlmpeek.BatchOptions{Raw: raw}
+	if !noIndex {
+		opts.LinesDir = linesDirFor(wsRoot)
+	}
+	results := rlmpeek.ReadBatch(reqs, opts)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(results)
+	for _, r := range results {
+		if r.Error != "" {
+			return 2
+		}
+	}
 	return 0
 }
 
+// parseRangeArg parses FILE:START:END (bytes) or FILE:LFROM:TO (lines). The
+// file name may itself contain colons; an empty END or TO means EOF.
+func parseRangeArg(v string) (rlmpeek.Request, error) {
+	i := strings.LastIndexByte(v, ':')
+	j := -1
+	if i > 0 {
+		j = strings.LastIndexByte(v[:i], ':')
+	}
+	if j <= 0 {
+		return rlmpeek.Request{}, fmt.Errorf("want FILE:START:END, got %q", v)
+	}
+	r := rlmpeek.Request{Path: v[:j]}
+	a, b := v[j+1:i], v[i+1:]
+	if strings.HasPrefix(a, "L") {
+		from, to, err := parseLineRange(a[1:] + ":" + b)
+		if err != nil {
+			return rlmpeek.Request{}, err
+		}
+		r.StartLine, r.EndLine = from, to
+		return r, nil
+	}
+	start, err := strconv.ParseInt(a, 10, 64)
+	if err != nil || start < 0 {
+		return rlmpeek.Request{}, fmt.Errorf("invalid start %q in %q", a, v)
+	}
+	r.Start, r.End = start, -1
+	if b != "" {
+		if r.End, err = strconv.ParseInt(b, 10, 64); err != nil || r.End < start {
+			return rlmpeek.Request{}, fmt.Errorf("invalid end %q in %q", b, v)
+		}
+	}
+	return r, nil
+}
+
+// lineSpan returns the lines covered by the byte range [s, e).
+func lineSpan(l *rlmindex.Lines, s, e int64) (rlmpeek.Range, error) {
+	first, err := l.LineOf(s)
+	if err != nil {
+		return rlmpeek.Range{}, err
+	}
+	last := first
+	if e > s {
+		if last, err = l.LineOf(e - 1); err != nil {
+			return rlmpeek.Range{}, err
+		}
+	}
+	return rlmpeek.Range{Start: s, End: e, StartLine: first, EndLine: last}, nil
+}
+
+// parseLineRange parses FROM:TO, or FROM: for a range to the end of the
+// file (TO is then 0).
+func parseLineRange(v string) (int, int, error) {
+	a, b, ok := strings.Cut(v, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("want FROM:TO, got %q", v)
+	}
+	from, err := strconv.Atoi(a)
+	if err != nil || from < 1 {
+		return 0, 0, fmt.Errorf("invalid start line %q", a)
+	}
+	to := 0
+	if b != "" {
+		if to, err = strconv.Atoi(b); err != nil || to < from {
+			return 0, 0, fmt.Errorf("end line %q must be a number >= %d", b, from)
+		}
+	}
+	return from, to, nil
+}
+
 func cmdChunk(argv []string) int {
+	if len(argv) > 0 && argv[0] == "get" {
+		return cmdChunkGet(argv[1:])
+	}
+
 	fs := flag.NewFlagSet("rlm chunk", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
 	dirFlag := fs.String("dir", "", "Override context directory")
-	size := fs.Int("size", 200_000, "Chunk size in bytes")
-	overlap := fs.Int("overlap", 0, "Overlap in bytes")
-	outDir := fs.String("out", "", "Output directory (default: <workspace>/.rlm/chunks)")
-	prefix := fs.String("prefix", "chunk", "Chunk filename prefix")
+	chunkOpts := chunkOptionFlags(fs)
+	force := fs.Bool("force", false, "Rewrite all chunks even if the source and options are unchanged")
+	outDir := fs.String("out", "", "Output directory (default: <workspace>/.rlm/chunks); - streams NDJSON to stdout")
+	all := fs.Bool("all", false, "Chunk every file in the context directory into <out>/<relative path>/")
+	filter := filterFlags(fs)
+	workers := fs.Int("workers", 0, "Files chunked in parallel with --all (0 = one per CPU)")
 	jsonOut := fs.Bool("json", true, "Output JSON")
+	format := fs.String("format", "", "Output format: json|ndjson|text (default: json, or text with --json=false); ndjson streams chunks instead of writing files")
 	argv = normalizeAndReorderArgs(fs, argv)
 	if err := fs.Parse(argv); err != nil {
 		return 2
 	}
+	outFormat := *format
+	switch outFormat {
+	case "":
+		outFormat = "json"
+		if *outDir == "-" {
+			outFormat = "ndjson"
+		} else if !*jsonOut {
+			outFormat = "text"
+		}
+	case "json", "ndjson", "text":
+	default:
+		fmt.Fprintln(os.Stderr, "--format must be json, ndjson or text")
+		return 2
+	}
+	if *outDir == "-" && outFormat != "ndjson" {
+		fmt.Fprintln(os.Stderr, "--out - requires --format ndjson")
+		return 2
+	}
 
 	args := fs.Args()
-	if len(args) != 1 {
-		fmt.Fprintln(os.Stderr, "Usage: rlm chunk <file> [--size N --overlap M --out DIR]")
+	if *all && len(args) != 0 || !*all && len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: rlm chunk <file>|--all [--size N --overlap M --unit bytes|tokens --boundary line|paragraph|sentence --strategy fixed|markdown|csv|jsonl|json-array|cdc --out DIR|- --format json|ndjson|text]")
+		fmt.Fprintln(os.Stderr, "       rlm chunk get <file> --index N|--count [chunk options]")
 		return 2
 	}
-	if *size <= 0 {
-		fmt.Fprintln(os.Stderr, "--size must be > 0")
+	if err := chunkOpts.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		return 2
 	}
-	if *overlap < 0 || *overlap >= *size {
-		fmt.Fprintln(os.Stderr, "--overlap must be >= 0 and < --size")
+	if err := filter.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		return 2
 	}
 
@@ -509,37 +963,384 @@ func cmdChunk(argv []string) int {
 		return 2
 	}
 
-	p := resolveFileArg(resolved.ContextDir, args[0])
 	if *outDir == "" {
 		*outDir = filepath.Join(wsRoot, ".rlm", "chunks")
 	}
 
-	paths, err := rlmchunk.WriteChunks(rlmchunk.Options{
-		InPath:   p,
-		OutDir:   *outDir,
-		Size:     *size,
-		Overlap:  *overlap,
-		Prefix:   *prefix,
-		Encoding: "utf-8",
-	})
+	opts := chunkOpts.options()
+	opts.Force = *force
+
+	if *all {
+		if outFormat == "ndjson" {
+			fmt.Fprintln(os.Stderr, "--all cannot be combined with ndjson output")
+			return 2
+		}
+		return chunkAll(resolved.ContextDir, *outDir, *filter, opts, *workers, outFormat == "json")
+	}
+
+	p := resolveFileArg(resolved.ContextDir, args[0])
+	if outFormat == "ndjson" {
+		opts.InPath = p
+		if err := rlmchunk.WriteNDJSON(os.Stdout, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+		return 0
+	}
+	opts.InPath, opts.OutDir = p, *outDir
+	res, err := rlmchunk.WriteChunks(opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		return 2
 	}
 
-	if *jsonOut {
+	if outFormat == "json" {
 		enc := json.NewEncoder(os.Stdout)
 		enc.SetIndent("", "  ")
-		_ = enc.Encode(map[string]any{"in": p, "out_dir": *outDir, "chunks": paths})
+		// Keep breadcrumbs such as "# A > ## B" readable.
+		enc.SetEscapeHTML(false)
+		_ = enc.Encode(chunkReport(p, *outDir, res))
 		return 0
 	}
 
-	for _, cp := range paths {
-		fmt.Println(cp)
+	if res.UpToDate {
+		fmt.Fprintln(os.Stderr, "chunks are up to date")
+	}
+	for _, c := range res.Chunks {
+		fmt.Println(c.Path)
 	}
 	return 0
 }
 
+// chunkFlags are the chunking options shared by chunk and chunk get.
+type chunkFlags struct {
+	size, overlap, minSize, maxSize  *int
+	unit, strategy, boundary, prefix *string
+	raw                              *bool
+}
+
+func chunkOptionFlags(fs *flag.FlagSet) *chunkFlags {
+	return &chunkFlags{
+		size:     fs.Int("size", 200_000, "Chunk size in --unit"),
+		overlap:  fs.Int("overlap", 0, "Overlap in --unit"),
+		unit:     fs.String("unit", "bytes", "Size unit: bytes or tokens (upper bound on the cl100k count)"),
+		strategy: fs.String("strategy", "fixed", "Chunking strategy: fixed, markdown, csv, jsonl, json-array or cdc"),
+		minSize:  fs.Int("min-size", 0, "Smallest cdc chunk in bytes (default: --size/4)"),
+		maxSize:  fs.Int("max-size", 0, "Largest cdc chunk in bytes (default: --size*4)"),
+		boundary: fs.String("boundary", "", "End chunks on a line, paragraph or sentence boundary (--size becomes a maximum)"),
+		raw:      fs.Bool("raw", false, "Cut at exact byte offsets instead of UTF-8 rune starts"),
+		prefix:   fs.String("prefix", "chunk", "Chunk filename prefix"),
+	}
+}
+
+func (c *chunkFlags) validate() error {
+	if *c.size <= 0 {
+		return fmt.Errorf("--size must be > 0")
+	}
+	if *c.overlap < 0 || *c.overlap >= *c.size {
+		return fmt.Errorf("--overlap must be >= 0 and < --size")
+	}
+	if *c.unit != "bytes" && *c.unit != "tokens" {
+		return fmt.Errorf("--unit must be bytes or tokens")
+	}
+	switch *c.boundary {
+	case "", rlmchunk.BoundaryLine, rlmchunk.BoundaryParagraph, rlmchunk.BoundarySentence:
+	default:
+		return fmt.Errorf("--boundary must be line, paragraph or sentence")
+	}
+	return nil
+}
+
+func (c *chunkFlags) options() rlmchunk.Options {
+	encoding := rlmchunk.EncodingUTF8
+	if *c.raw {
+		encoding = rlmchunk.EncodingRaw
+	}
+	return rlmchunk.Options{
+		Size:     *c.size,
+		Overlap:  *c.overlap,
+		Prefix:   *c.prefix,
+		Encoding: encoding,
+		Unit:     *c.unit,
+		Boundary: *c.boundary,
+		Strategy: *c.strategy,
+		MinSize:  *c.minSize,
+		MaxSize:  *c.maxSize,
+	}
+}
+
+// cmdChunkGet prints a single chunk of a file, or the number of chunks,
+// without writing any. The chunk options must match those of the run whose
+// chunks are referred to.
+func cmdChunkGet(argv []string) int {
+	fs := flag.NewFlagSet("rlm chunk get", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	dirFlag := fs.String("dir", "", "Override context directory")
+	chunkOpts := chunkOptionFlags(fs)
+	outDir := fs.String("out", "", "Directory of a previous chunk run whose manifest may be used (default: <workspace>/.rlm/chunks)")
+	index := fs.Int("index", -1, "Index of the chunk to print (0-based)")
+	count := fs.Bool("count", false, "Print the number of chunks instead")
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	argv = normalizeAndReorderArgs(fs, argv)
+	if err := fs.Parse(argv); err != nil {
+		return 2
+	}
+
+	args := fs.Args()
+	if len(args) != 1 || *count == (*index >= 0) {
+		fmt.Fprintln(os.Stderr, "Usage: rlm chunk get <file> --index N|--count [--size N --overlap M --unit bytes|tokens --boundary ... --strategy ...]")
+		return 2
+	}
+	if err := chunkOpts.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	wsRoot, err := rlmconfig.DetectWorkspaceRoot("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
+	resolved, err := rlmconfig.Resolve(rlmconfig.ResolveOptions{WorkspaceRoot: wsRoot, DirFlag: *dirFlag})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
+	if *outDir == "" {
+		*outDir = filepath.Join(wsRoot, ".rlm", "chunks")
+	}
+
+	p := resolveFileArg(resolved.ContextDir, args[0])
+	opts := chunkOpts.options()
+	opts.InPath, opts.OutDir = p, *outDir
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	if *count {
+		n, err := rlmchunk.Count(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+		if *jsonOut {
+			_ = enc.Encode(map[string]any{"path": p, "chunks": n})
+			return 0
+		}
+		fmt.Println(n)
+		return 0
+	}
+
+	c, text, err := rlmchunk.Get(opts, *index)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
+	if *jsonOut {
+		_ = enc.Encode(map[string]any{"path": p, "chunk": rlmchunk.Record{Chunk: c, Text: string(text)}})
+		return 0
+	}
+	_, _ = os.Stdout.Write(text)
+	return 0
+}
+
+// chunkReport is the JSON output for one chunked file.
+func chunkReport(in, outDir string, res rlmchunk.Result) map[string]any {
+	paths := make([]string, len(res.Chunks))
+	for i, c := range res.Chunks {
+		paths[i] = c.Path
+	}
+	return map[string]any{
+		"in":              in,
+		"out_dir":         outDir,
+		"manifest":        rlmchunk.ManifestPath(outDir, res.Params.Prefix),
+		"version":         res.Version,
+		"source":          res.Source,
+		"params":          res.Params,
+		"chunks":          paths,
+		"manifest_chunks": res.Chunks,
+		"up_to_date":      res.UpToDate,
+		"written":         res.Written,
+		"unchanged":       res.Unchanged,
+		"removed":         res.Removed,
+	}
+}
+
+// chunkAll chunks every file of the context directory into
+// outDir/<relative path>/ and prints one combined report.
+func chunkAll(contextDir, outDir string, filter rlmfilter.Filter, opts rlmchunk.Options, workers int, jsonOut bool) int {
+	files, err := rlmfiles.List(contextDir, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
+
+	rels := make([]string, len(files))
+	jobs := make([]rlmchunk.Options, len(files))
+	for i, fi := range files {
+		rel, err := filepath.Rel(contextDir, fi.Path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+		rels[i] = filepath.ToSlash(rel)
+		jobs[i] = opts
+		jobs[i].InPath = fi.Path
+		jobs[i].OutDir = filepath.Join(outDir, rel)
+	}
+
+	outcomes := rlmchunk.WriteAll(jobs, workers)
+	reports := make([]map[string]any, len(outcomes))
+	totalChunks, failed := 0, 0
+	for i, o := range outcomes {
+		if o.Err != nil {
+			failed++
+			reports[i] = map[string]any{"path": rels[i], "in": jobs[i].InPath, "error": o.Err.Error()}
+			if !jsonOut {
+				fmt.Fprintf(os.Stderr, "ERROR: %s: %v\n", rels[i], o.Err)
+			}
+			continue
+		}
+		totalChunks += len(o.Chunks)
+		reports[i] = chunkReport(jobs[i].InPath, jobs[i].OutDir, o.Result)
+		reports[i]["path"] = rels[i]
+	}
+
+	code := 0
+	if failed > 0 {
+		code = 2
+	}
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		_ = enc.Encode(map[string]any{
+			"context_dir":  contextDir,
+			"out_dir":      outDir,
+			"total_files":  len(files),
+			"total_chunks": totalChunks,
+			"failed":       failed,
+			"files":        reports,
+		})
+		return code
+	}
+	for _, o := range outcomes {
+		for _, c := range o.Chunks {
+			fmt.Println(c.Path)
+		}
+	}
+	return code
+}
+
+func cmdIndex(argv []string) int {
+	if len(argv) == 0 {
+		fmt.Fprintln(os.Stderr, "Missing subcommand: build|lines")
+		return 2
+	}
+
+	sub := argv[0]
+	args := argv[1:]
+
+	switch sub {
+	case "build":
+		fs := flag.NewFlagSet("rlm index build", flag.ContinueOnError)
+		fs.SetOutput(os.Stderr)
+		dirFlag := fs.String("dir", "", "Override context directory")
+		blockSize := fs.Int("block-size", rlmindex.DefaultBlockSize, "Approximate bytes per indexed block")
+		jsonOut := fs.Bool("json", true, "Output JSON")
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+		if *blockSize <= 0 {
+			fmt.Fprintln(os.Stderr, "--block-size must be > 0")
+			return 2
+		}
+
+		wsRoot, err := rlmconfig.DetectWorkspaceRoot("")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+		resolved, err := rlmconfig.Resolve(rlmconfig.ResolveOptions{WorkspaceRoot: wsRoot, DirFlag: *dirFlag})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+
+		stats, err := rlmindex.Build(rlmindex.Options{
+			ContextDir: resolved.ContextDir,
+			IndexDir:   indexDirFor(wsRoot),
+			BlockSize:  *blockSize,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+
+		if *jsonOut {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(stats)
+			return 0
+		}
+		fmt.Printf("Indexed %d files (%d bytes) into %s\n", stats.Files, stats.Bytes, stats.IndexDir)
+		return 0
+
+	case "lines":
+		fs := flag.NewFlagSet("rlm index lines", flag.ContinueOnError)
+		fs.SetOutput(os.Stderr)
+		dirFlag := fs.String("dir", "", "Override context directory")
+		jsonOut := fs.Bool("json", true, "Output JSON")
+		args = normalizeAndReorderArgs(fs, args)
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: rlm index lines <file>")
+			return 2
+		}
+
+		wsRoot, err := rlmconfig.DetectWorkspaceRoot("")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+		resolved, err := rlmconfig.Resolve(rlmconfig.ResolveOptions{WorkspaceRoot: wsRoot, DirFlag: *dirFlag})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+
+		stats, err := rlmindex.BuildLines(linesDirFor(wsRoot), resolveFileArg(resolved.ContextDir, fs.Arg(0)))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+
+		if *jsonOut {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(stats)
+			return 0
+		}
+		fmt.Printf("Indexed %d lines (%d bytes) of %s into %s\n", stats.Lines, stats.Bytes, stats.Path, stats.Sidecar)
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown index subcommand: %s\n", sub)
+		return 2
+	}
+}
+
+func indexDirFor(wsRoot string) string {
+	return filepath.Join(wsRoot, ".rlm", "index")
+}
+
+func linesDirFor(wsRoot string) string {
+	return filepath.Join(wsRoot, ".rlm", "lines")
+}
+
 func resolveFileArg(contextDir, arg string) string {
 	if filepath.IsAbs(arg) {
 		return arg
@@ -547,6 +1348,26 @@ func resolveFileArg(contextDir, arg string) string {
 	return filepath.Join(contextDir, arg)
 }
 
+// filterFlags registers the --include/--exclude/--max-file-size flags shared
+// by commands that walk the context directory.
+func filterFlags(fs *flag.FlagSet) *rlmfilter.Filter {
+	f := &rlmfilter.Filter{}
+	fs.Var((*stringList)(&f.Include), "include", "Only consider paths matching this glob (repeatable)")
+	fs.Var((*stringList)(&f.Exclude), "exclude", "Skip paths matching this glob (repeatable)")
+	fs.Int64Var(&f.MaxSize, "max-file-size", 0, "Skip files larger than this many bytes (0 = no limit)")
+	return f
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 type boolFlag interface {
 	IsBoolFlag() bool
 }
@@ -567,9 +1388,11 @@ func normalizeAndReorderArgs(fs *flag.FlagSet, argv []string) []string {
 	flags := make([]string, 0, len(norm))
 	pos := make([]string, 0, len(norm))
 
+	// A lone "-" (stdin or stdout) is a value, never a flag.
+	isFlag := func(a string) bool { return strings.HasPrefix(a, "-") && a != "-" }
 	for i := 0; i < len(norm); i++ {
 		a := norm[i]
-		if strings.HasPrefix(a, "-") {
+		if isFlag(a) {
 			flags = append(flags, a)
 
 			name := strings.TrimLeft(a, "-")
@@ -585,7 +1408,7 @@ func normalizeAndReorderArgs(fs *flag.FlagSet, argv []string) []string {
 			}
 
 			if !isBool && !strings.Contains(a, "=") {
-				if i+1 < len(norm) && !strings.HasPrefix(norm[i+1], "-") {
+				if i+1 < len(norm) && !isFlag(norm[i+1]) {
 					flags = append(flags, norm[i+1])
 					i++
 				}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// runCaptured runs the CLI with argv and returns its exit code and stdout.
+func runCaptured(t *testing.T, argv ...string) (int, string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	code := run(argv)
+	os.Stdout = stdout
+	_ = w.Close()
+	return code, string(<-done)
+}
+
+func TestChunk_StdoutOutBeforeFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(in, []byte("0123456789abcdef"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var outputs []string
+	for _, argv := range [][]string{
+		{"chunk", "--dir", dir, in, "--size", "8", "--out", "-"},
+		{"chunk", "--dir", dir, "--out", "-", in, "--size", "8"},
+		{"chunk", "--dir", dir, "--size", "8", "--out", "-", in},
+	} {
+		code, out := runCaptured(t, argv...)
+		if code != 0 {
+			t.Fatalf("%q exited %d", argv, code)
+		}
+		outputs = append(outputs, out)
+	}
+
+	var texts []string
+	dec := json.NewDecoder(bytes.NewReader([]byte(outputs[0])))
+	for dec.More() {
+		var rec struct{ Text string }
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		texts = append(texts, rec.Text)
+	}
+	if len(texts) != 2 || texts[0] != "01234567" || texts[1] != "89abcdef" {
+		t.Errorf("chunks = %q", texts)
+	}
+	for i, out := range outputs[1:] {
+		if out != outputs[0] {
+			t.Errorf("argument order %d gave %q, want %q", i+1, out, outputs[0])
+		}
+	}
+}
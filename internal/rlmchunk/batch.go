@@ -0,0 +1,42 @@
+package rlmchunk
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Outcome is the result of one job of WriteAll.
+type Outcome struct {
+	Result
+	Err error
+}
+
+// WriteAll runs WriteChunks for every job on up to workers goroutines (0
+// means one per CPU) and returns the outcomes in job order. Jobs should not
+// share an output directory.
+func WriteAll(jobs []Options, workers int) []Outcome {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	workers = min(workers, max(len(jobs), 1))
+
+	out := make([]Outcome, len(jobs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				res, err := WriteChunks(jobs[i])
+				out[i] = Outcome{Result: res, Err: err}
+			}
+		}()
+	}
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return out
+}
@@ -0,0 +1,331 @@
+package rlmchunk
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Boundaries accepted in Options.Boundary.
+const (
+	BoundaryLine      = "line"
+	BoundaryParagraph = "paragraph"
+	BoundarySentence  = "sentence"
+)
+
+func validateBoundary(b string) error {
+	switch b {
+	case "", BoundaryLine, BoundaryParagraph, BoundarySentence:
+		return nil
+	}
+	return fmt.Errorf("boundary must be line, paragraph or sentence")
+}
+
+// point is a candidate cut position: off is a file offset and cum the size,
+// in Options.Unit, of everything before it.
+type point struct {
+	off, cum int64
+	boundary bool
+}
+
+// planUnitSpans packs whole units (lines, paragraphs or sentences) into
+// chunks of at most Size, cutting inside a unit only when it alone exceeds
+// Size. Overlap repeats as many trailing whole units of the previous chunk
+// as fit in Overlap.
+func planUnitSpans(f *os.File, size int64, opts Options, fn func(span) error) error {
+	pk := &packer{f: f, size: size, opts: opts, fn: fn}
+	sc := &boundaryScanner{mode: opts.Boundary}
+	sg := newSegmenter(f, opts.Encoding)
+	tokens := opts.Unit == "tokens"
+
+	var cum, end int64
+	for {
+		seg, err := sg.next()
+		if err != nil {
+			return err
+		}
+		if seg == nil {
+			break
+		}
+		bounds := sc.scan(seg)
+		end = sg.pos + int64(len(seg))
+		if !tokens {
+			for _, b := range bounds {
+				off := sg.pos + int64(b)
+				if err := pk.add(point{off: off, cum: off, boundary: true}); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// Every token end is a point, so oversized units can be cut on
+		// token boundaries; unit ends are flagged.
+		i := 0
+		for _, e := range opts.Tokenizer.Split(seg) {
+			for ; i < len(bounds) && bounds[i] < e; i++ {
+				if err := pk.add(point{off: sg.pos + int64(bounds[i]), cum: cum, boundary: true}); err != nil {
+					return err
+				}
+			}
+			cum++
+			isBound := i < len(bounds) && bounds[i] == e
+			if isBound {
+				i++
+			}
+			if err := pk.add(point{off: sg.pos + int64(e), cum: cum, boundary: isBound}); err != nil {
+				return err
+			}
+		}
+		for ; i < len(bounds); i++ {
+			if err := pk.add(point{off: sg.pos + int64(bounds[i]), cum: cum, boundary: true}); err != nil {
+				return err
+			}
+		}
+	}
+	if end == 0 {
+		return nil
+	}
+	last := end
+	if tokens {
+		last = cum
+	}
+	if err := pk.add(point{off: end, cum: last, boundary: true}); err != nil {
+		return err
+	}
+	return pk.flush()
+}
+
+// packer turns a stream of points into chunk spans.
+type packer struct {
+	f    *os.File
+	size int64
+	opts Options
+	fn   func(span) error
+
+	start point   // start of the current chunk
+	last  point   // end of the last emitted chunk
+	pts   []point // points after start, all within Size of it
+}
+
+func (p *packer) add(pt point) error {
+	if n := len(p.pts); n > 0 && p.pts[n-1].off == pt.off {
+		// Tokens ending at the same offset are one point, counting them all.
+		pt.boundary = pt.boundary || p.pts[n-1].boundary
+		pt.cum = max(pt.cum, p.pts[n-1].cum)
+		p.pts = p.pts[:n-1]
+	}
+	if pt.off <= p.start.off {
+		return nil
+	}
+	for pt.cum-p.start.cum > int64(p.opts.Size) {
+		if err := p.cut(pt); err != nil {
+			return err
+		}
+	}
+	p.pts = append(p.pts, pt)
+	return nil
+}
+
+// cut emits a chunk from start, ending at the last unit boundary within Size
+// or, when there is none, at a hard cut, and moves start to the overlap.
+// next is the point that did not fit.
+func (p *packer) cut(next point) error {
+	end, hard := point{}, true
+	for i := len(p.pts) - 1; i >= 0; i-- {
+		if p.pts[i].boundary {
+			end, hard = p.pts[i], false
+			break
+		}
+	}
+	if hard {
+		var err error
+		if end, err = p.hardCut(next); err != nil {
+			return err
+		}
+	}
+	if end.off <= p.last.off {
+		// Everything up to end was in the previous chunk already: drop the
+		// overlap instead of emitting a chunk it contains.
+		p.advance(p.last)
+		return nil
+	}
+	if err := p.emit(end); err != nil {
+		return err
+	}
+
+	start := end
+	if p.opts.Overlap > 0 {
+		if hard && p.opts.Unit != "tokens" {
+			off, err := p.snap(end.off - int64(p.opts.Overlap))
+			if err != nil {
+				return err
+			}
+			if off > p.start.off {
+				start = point{off: off, cum: off}
+			}
+		} else {
+			for _, q := range p.pts {
+				if q.off < end.off && (q.boundary || hard) && end.cum-q.cum <= int64(p.opts.Overlap) {
+					start = q
+					break
+				}
+			}
+		}
+	}
+	p.advance(start)
+	return nil
+}
+
+// hardCut returns the furthest point within Size of start: the last token
+// end with Unit "tokens", otherwise start+Size moved to a rune start.
+func (p *packer) hardCut(next point) (point, error) {
+	if p.opts.Unit == "tokens" {
+		if len(p.pts) == 0 {
+			return next, nil
+		}
+		return p.pts[len(p.pts)-1], nil
+	}
+	off, err := p.snap(p.start.off + int64(p.opts.Size))
+	if err != nil {
+		return point{}, err
+	}
+	if off <= p.start.off {
+		if off, err = nextRuneStart(p.f, p.start.off, p.size); err != nil {
+			return point{}, err
+		}
+	}
+	return point{off: off, cum: off}, nil
+}
+
+func (p *packer) snap(off int64) (int64, error) {
+	if p.opts.Encoding == EncodingRaw {
+		return off, nil
+	}
+	return RuneStart(p.f, off, p.size)
+}
+
+func (p *packer) emit(end point) error {
+	sp := span{start: p.start.off, end: end.off}
+	if p.opts.Unit == "tokens" {
+		sp.tokens = int(end.cum - p.start.cum)
+	}
+	p.last = end
+	return p.fn(sp)
+}
+
+// advance moves the chunk start to pt, dropping the points before it.
+func (p *packer) advance(pt point) {
+	i := 0
+	for i < len(p.pts) && p.pts[i].off <= pt.off {
+		i++
+	}
+	p.pts = append(p.pts[:0], p.pts[i:]...)
+	p.start = pt
+}
+
+// flush emits the final chunk.
+func (p *packer) flush() error {
+	if n := len(p.pts); n > 0 && p.pts[n-1].off > p.last.off {
+		return p.emit(p.pts[n-1])
+	}
+	return nil
+}
+
+// boundaryScanner finds unit ends in consecutive segments of a file. Offsets
+// are relative to the segment; the end of the file is always a boundary and
+// is not reported.
+type boundaryScanner struct {
+	mode  string
+	blank bool // the previous line was blank
+	text  bool // a non-blank line has been seen
+}
+
+func (b *boundaryScanner) scan(seg []byte) []int {
+	var out []int
+	for i := 0; i < len(seg); {
+		lineEnd := len(seg)
+		full := false
+		if j := bytes.IndexByte(seg[i:], '\n'); j >= 0 {
+			lineEnd, full = i+j+1, true
+		}
+		line := seg[i:lineEnd]
+		isBlank := len(bytes.TrimSpace(line)) == 0
+
+		switch b.mode {
+		case BoundaryLine:
+			if full {
+				out = append(out, lineEnd)
+			}
+		case BoundaryParagraph, BoundarySentence:
+			// A paragraph starts at the first non-blank line after blank
+			// ones; the blank lines end the previous paragraph.
+			if !isBlank && b.blank && b.text {
+				if n := len(out); n == 0 || out[n-1] != i {
+					out = append(out, i)
+				}
+			}
+			if b.mode == BoundarySentence && !isBlank {
+				out = appendSentenceEnds(out, line, i)
+			}
+		}
+
+		if full {
+			b.blank = isBlank
+		} else if !isBlank {
+			b.blank = false
+		}
+		if !isBlank {
+			b.text = true
+		}
+		i = lineEnd
+	}
+	return out
+}
+
+// sentenceClosers may follow a sentence terminator before the whitespace
+// that ends the sentence.
+const sentenceClosers = `)]"'”’»`
+
+// appendSentenceEnds appends base plus the end of every sentence in line: a
+// run of '.', '!' or '?', optionally followed by closing quotes or brackets,
+// then whitespace, which belongs to the sentence it ends.
+func appendSentenceEnds(out []int, line []byte, base int) []int {
+	for k := 0; k < len(line); k++ {
+		if !isTerminator(line[k]) {
+			continue
+		}
+		j := k + 1
+		for j < len(line) && isTerminator(line[j]) {
+			j++
+		}
+		for j < len(line) {
+			r, w := utf8.DecodeRune(line[j:])
+			if !strings.ContainsRune(sentenceClosers, r) {
+				break
+			}
+			j += w
+		}
+		if j < len(line) && !isSpaceByte(line[j]) {
+			// "3.14", "example.com" and the like.
+			k = j - 1
+			continue
+		}
+		for j < len(line) && isSpaceByte(line[j]) {
+			j++
+		}
+		out = append(out, base+j)
+		k = j - 1
+	}
+	return out
+}
+
+func isTerminator(c byte) bool {
+	return c == '.' || c == '!' || c == '?'
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
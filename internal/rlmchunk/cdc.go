@@ -0,0 +1,93 @@
+package rlmchunk
+
+import (
+	"io"
+	"math/bits"
+	"os"
+	"unicode/utf8"
+)
+
+// gear maps each byte to a pseudo-random 64-bit value for the rolling hash.
+// The table is fixed so that boundaries are stable across runs and builds.
+var gear = func() (t [256]uint64) {
+	// splitmix64
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// planCDCSpans cuts content-defined chunks (FastCDC): a Gear rolling hash is
+// computed from MinSize bytes into each chunk, and a chunk ends where the
+// hash's top bits are all zero. The test uses more bits before the target
+// size Size and fewer after it, which keeps sizes close to Size, and a chunk
+// never exceeds MaxSize. Since cut points depend only on nearby content, an
+// edit changes the chunks around it and leaves the rest as they were.
+func planCDCSpans(f *os.File, size int64, opts Options, fn func(span) error) error {
+	n := bits.Len(uint(opts.Size)) - 1
+	maskS := topBits(n + 1) // before Size: harder to match
+	maskL := topBits(n - 1) // after Size: easier to match
+
+	buf := make([]byte, opts.MaxSize)
+	for start := int64(0); start < size; {
+		k, err := f.ReadAt(buf[:min(int64(len(buf)), size-start)], start)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		b := buf[:k]
+		if k == 0 {
+			break
+		}
+
+		cut := cdcCut(b, opts.MinSize, opts.Size, maskS, maskL)
+		if opts.Encoding == EncodingUTF8 && cut < len(b) {
+			for c := cut; c > opts.MinSize && c > cut-utf8.UTFMax; c-- {
+				if utf8.RuneStart(b[c]) {
+					cut = c
+					break
+				}
+			}
+		}
+		if err := fn(span{start: start, end: start + int64(cut)}); err != nil {
+			return err
+		}
+		start += int64(cut)
+	}
+	return nil
+}
+
+// cdcCut returns the length of the chunk at the start of b, which holds at
+// most MaxSize bytes.
+func cdcCut(b []byte, minSize, avgSize int, maskS, maskL uint64) int {
+	if len(b) <= minSize {
+		return len(b)
+	}
+	normal := min(avgSize, len(b))
+	var h uint64
+	i := minSize
+	for ; i < normal; i++ {
+		h = h<<1 + gear[b[i]]
+		if h&maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < len(b); i++ {
+		h = h<<1 + gear[b[i]]
+		if h&maskL == 0 {
+			return i + 1
+		}
+	}
+	return len(b)
+}
+
+// topBits returns a mask of the n most significant bits. The top bits of a
+// Gear hash depend on the last 64 bytes, the low ones on only a few.
+func topBits(n int) uint64 {
+	n = max(1, min(n, 63))
+	return ^uint64(0) << (64 - n)
+}
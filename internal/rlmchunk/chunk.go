@@ -1,6 +1,9 @@
 package rlmchunk
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -8,87 +11,538 @@ import (
 )
 
 type Options struct {
-	InPath   string
-	OutDir   string
-	Size     int
-	Overlap  int
-	Prefix   string
+	InPath  string
+	OutDir  string
+	Size    int
+	Overlap int
+	Prefix  string
+	// Encoding is EncodingUTF8 (the default), which moves every boundary
+	// back to the start of the rune it falls in, or EncodingRaw for
+	// byte-exact cuts.
 	Encoding string
+	// Unit is "bytes" (the default) or "tokens"; Size and Overlap are
+	// expressed in it.
+	Unit string
+	// Tokenizer counts tokens when Unit is "tokens"; nil means
+	// ApproxTokenizer.
+	Tokenizer Tokenizer
+	// Boundary, when set, makes Size a soft maximum: chunks end at the last
+	// BoundaryLine, BoundaryParagraph or BoundarySentence that fits, and
+	// Overlap is made of whole units.
+	Boundary string
+	// Strategy is StrategyFixed (the default), StrategyMarkdown or one of
+	// the record strategies.
+	Strategy string
+	// MinSize and MaxSize bound chunk sizes with StrategyCDC, where Size is
+	// the target average; they default to Size/4 and Size*4.
+	MinSize, MaxSize int
+	// Force rewrites every chunk even if the previous run is up to date.
+	Force bool
 }
 
-func WriteChunks(opts Options) ([]string, error) {
-	if opts.InPath == "" {
-		return nil, fmt.Errorf("in_path is required")
-	}
+// Strategies accepted in Options.Strategy.
+const (
+	StrategyFixed    = "fixed"
+	StrategyMarkdown = "markdown"
+	// StrategyCSV splits between CSV records and repeats the header row at
+	// the top of every chunk.
+	StrategyCSV = "csv"
+	// StrategyJSONL splits between JSON Lines records.
+	StrategyJSONL = "jsonl"
+	// StrategyJSONArray splits between the elements of a top-level JSON
+	// array and writes each chunk as an array.
+	StrategyJSONArray = "json-array"
+	// StrategyCDC cuts content-defined chunks with a rolling hash, so that
+	// an edit does not move the boundaries of unrelated chunks.
+	StrategyCDC = "cdc"
+)
+
+type Chunk struct {
+	Index int    `json:"index"`
+	Path  string `json:"path,omitempty"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	// Tokens is reported when chunking by tokens.
+	Tokens int `json:"tokens,omitempty"`
+	// StartLine and EndLine are the 1-based source lines of the first and
+	// last byte of the chunk.
+	StartLine int `json:"start_line"`
+	EndLine   int `json:"end_line"`
+	// SHA256 is the hash of the chunk's content.
+	SHA256 string `json:"sha256"`
+	// Breadcrumb is the heading path of the section a Markdown chunk
+	// starts in, e.g. "# Guide > ## Install".
+	Breadcrumb string `json:"breadcrumb,omitempty"`
+}
+
+// Result is the outcome of WriteChunks.
+type Result struct {
+	Manifest
+	// UpToDate is set when the source and parameters match the previous
+	// run's manifest, so nothing was written.
+	UpToDate bool
+	// Written and Unchanged count chunk files rewritten and those left in
+	// place because their content did not change.
+	Written, Unchanged int
+	// Removed lists chunk files of the previous run that no longer exist.
+	Removed []string
+}
+
+// WriteChunks writes the chunks of opts.InPath to opts.OutDir, along with a
+// manifest (see ManifestPath). When the directory holds the manifest of an
+// earlier run with the same prefix, only chunk files whose content changed
+// are rewritten, and chunk files the new run no longer produces are deleted;
+// an unchanged source with the same parameters is not chunked again unless
+// opts.Force is set.
+func WriteChunks(opts Options) (Result, error) {
 	if opts.OutDir == "" {
-		return nil, fmt.Errorf("out_dir is required")
+		return Result{}, fmt.Errorf("out_dir is required")
 	}
-	if opts.Size <= 0 {
-		return nil, fmt.Errorf("size must be > 0")
-	}
-	if opts.Overlap < 0 || opts.Overlap >= opts.Size {
-		return nil, fmt.Errorf("overlap must be >= 0 and < size")
-	}
-	if opts.Prefix == "" {
-		opts.Prefix = "chunk"
+	opts, err := normalize(opts)
+	if err != nil {
+		return Result{}, err
 	}
 
 	if err := os.MkdirAll(opts.OutDir, 0o755); err != nil {
-		return nil, err
+		return Result{}, err
 	}
 
 	f, err := os.Open(opts.InPath)
 	if err != nil {
-		return nil, err
+		return Result{}, err
 	}
 	defer f.Close()
 
 	st, err := f.Stat()
 	if err != nil {
-		return nil, err
+		return Result{}, err
+	}
+
+	src := Source{Path: opts.InPath, Size: st.Size(), ModTime: st.ModTime()}
+	params := paramsOf(opts)
+
+	// A previous run into the same directory lets unchanged work be
+	// skipped; a missing or unreadable manifest just means a full run.
+	prev, err := ReadManifest(opts.OutDir, opts.Prefix)
+	hasPrev := err == nil
+	if hasPrev && !opts.Force && prev.sameRun(src, params) && prev.Source.ModTime.Equal(src.ModTime) {
+		return Result{Manifest: prev, UpToDate: true, Removed: []string{}}, nil
 	}
 
-	step := opts.Size - opts.Overlap
-	buf := make([]byte, opts.Size)
+	if src.SHA256, err = hashReader(io.NewSectionReader(f, 0, st.Size())); err != nil {
+		return Result{}, err
+	}
+	if hasPrev && !opts.Force && prev.sameRun(src, params) && prev.Source.SHA256 == src.SHA256 {
+		// Touched but not modified.
+		prev.Source.ModTime = src.ModTime
+		if err := writeManifest(opts.OutDir, prev); err != nil {
+			return Result{}, err
+		}
+		return Result{Manifest: prev, UpToDate: true, Removed: []string{}}, nil
+	}
 
-	var out []string
-	for i := 0; ; i++ {
-		name := fmt.Sprintf("%s_%04d.txt", opts.Prefix, i)
-		p := filepath.Join(opts.OutDir, name)
+	// Chunk files of the same source may be kept when unchanged. Those of
+	// another source under this prefix are overwritten by name, so they are
+	// replaced or removed like stale ones rather than left untracked.
+	previous := map[string]Chunk{}
+	if prev.Source.Path == src.Path {
+		for _, c := range prev.Chunks {
+			previous[c.Path] = c
+		}
+	}
+
+	res := Result{Manifest: Manifest{
+		Version: manifestVersion,
+		Source:  src,
+		Params:  params,
+		Chunks:  []Chunk{},
+	}, Removed: []string{}}
+	m := &res.Manifest
 
-		n, readErr := io.ReadFull(f, buf)
-		if readErr != nil {
-			// io.ReadFull returns err != nil even if n>0 (e.g. EOF). We still want to write the partial chunk.
-			if readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
-				return nil, readErr
+	err = eachChunk(f, st.Size(), opts, func(c Chunk, text []byte) error {
+		c.Path = filepath.Join(opts.OutDir, fmt.Sprintf("%s_%04d.txt", opts.Prefix, c.Index))
+		if pc, ok := previous[c.Path]; ok && !opts.Force && pc.SHA256 == c.SHA256 && fileHasSize(c.Path, len(text)) {
+			res.Unchanged++
+		} else {
+			if err := os.WriteFile(c.Path, text, 0o644); err != nil {
+				return err
 			}
+			res.Written++
 		}
+		m.Chunks = append(m.Chunks, c)
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
 
-		if n == 0 {
-			break
+	// Remove chunk files of the previous run that this one did not produce.
+	current := make(map[string]bool, len(m.Chunks))
+	for _, c := range m.Chunks {
+		current[c.Path] = true
+	}
+	for _, c := range prev.Chunks {
+		if current[c.Path] {
+			continue
 		}
+		if err := os.Remove(c.Path); err != nil && !os.IsNotExist(err) {
+			return Result{}, err
+		}
+		res.Removed = append(res.Removed, c.Path)
+	}
 
-		if err := os.WriteFile(p, buf[:n], 0o644); err != nil {
-			return nil, err
+	if err := writeManifest(opts.OutDir, *m); err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// Each calls fn, in order, for every chunk of opts.InPath with its content,
+// without touching the filesystem; OutDir is ignored and the chunks have no
+// Path. text is only valid during the call. An error from fn stops the run
+// and is returned.
+func Each(opts Options, fn func(c Chunk, text []byte) error) error {
+	opts, err := normalize(opts)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(opts.InPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return eachChunk(f, st.Size(), opts, fn)
+}
+
+// Record is a chunk with its content, as written by WriteNDJSON.
+type Record struct {
+	Chunk
+	Text string `json:"text"`
+}
+
+// WriteNDJSON writes every chunk of opts.InPath to w as one JSON Record per
+// line.
+func WriteNDJSON(w io.Writer, opts Options) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return Each(opts, func(c Chunk, text []byte) error {
+		return enc.Encode(Record{Chunk: c, Text: string(text)})
+	})
+}
+
+// normalize validates opts and fills in defaults.
+func normalize(opts Options) (Options, error) {
+	if opts.InPath == "" {
+		return opts, fmt.Errorf("in_path is required")
+	}
+	if opts.Size <= 0 {
+		return opts, fmt.Errorf("size must be > 0")
+	}
+	if opts.Overlap < 0 || opts.Overlap >= opts.Size {
+		return opts, fmt.Errorf("overlap must be >= 0 and < size")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "chunk"
+	}
+	enc, err := normalizeEncoding(opts.Encoding)
+	if err != nil {
+		return opts, err
+	}
+	opts.Encoding = enc
+	if err := validateBoundary(opts.Boundary); err != nil {
+		return opts, err
+	}
+	switch opts.Unit {
+	case "", "bytes":
+		opts.Unit = "bytes"
+	case "tokens":
+		if opts.Tokenizer == nil {
+			opts.Tokenizer = ApproxTokenizer{}
+		}
+	default:
+		return opts, fmt.Errorf("unit must be bytes or tokens")
+	}
+	switch opts.Strategy {
+	case "", StrategyFixed:
+		opts.Strategy = StrategyFixed
+	case StrategyMarkdown, StrategyCSV, StrategyJSONL, StrategyJSONArray:
+		if opts.Overlap > 0 || opts.Boundary != "" {
+			return opts, fmt.Errorf("strategy %s does not support overlap or boundary", opts.Strategy)
+		}
+	case StrategyCDC:
+		if opts.Overlap > 0 || opts.Boundary != "" || opts.Unit != "bytes" {
+			return opts, fmt.Errorf("strategy cdc only supports byte sizes without overlap or boundary")
+		}
+		if opts.MinSize == 0 {
+			opts.MinSize = max(1, opts.Size/4)
+		}
+		if opts.MaxSize == 0 {
+			opts.MaxSize = opts.Size * 4
 		}
-		out = append(out, p)
+		if opts.MinSize < 1 || opts.MinSize > opts.Size || opts.MaxSize < opts.Size {
+			return opts, fmt.Errorf("cdc sizes must satisfy 1 <= min <= size <= max")
+		}
+	default:
+		return opts, fmt.Errorf("strategy must be fixed, markdown, csv, jsonl, json-array or cdc")
+	}
+	return opts, nil
+}
+
+// eachChunk plans the chunks of f and calls fn with each one's metadata and
+// content.
+func eachChunk(f *os.File, size int64, opts Options, fn func(Chunk, []byte) error) error {
+	cr := newChunkReader(f, opts)
+	index := 0
+	return planSpans(f, size, opts, func(sp span) error {
+		c, text, err := cr.read(sp, index)
+		if err != nil {
+			return err
+		}
+		index++
+		return fn(c, text)
+	})
+}
+
+// chunkReader turns planned spans into chunks.
+type chunkReader struct {
+	f     *os.File
+	lines *lineCounter
+	buf   []byte
+}
+
+func newChunkReader(f *os.File, opts Options) *chunkReader {
+	return &chunkReader{f: f, lines: newLineCounter(f), buf: make([]byte, 0, opts.Size)}
+}
+
+// read returns the chunk for sp and its content: the source bytes with the
+// span's prefix and suffix. The content is only valid until the next call.
+func (r *chunkReader) read(sp span, index int) (Chunk, []byte, error) {
+	n := int(sp.end - sp.start)
+	buf := append(r.buf[:0], sp.prefix...)
+	if k := len(buf) + n; cap(buf) < k {
+		buf = append(make([]byte, 0, k), buf...)
+	}
+	if _, err := r.f.ReadAt(buf[len(buf):len(buf)+n], sp.start); err != nil && err != io.EOF {
+		return Chunk{}, nil, err
+	}
+	text := buf[len(buf) : len(buf)+n]
+	buf = append(buf[:len(buf)+n], sp.suffix...)
+	r.buf = buf
+
+	startLine, err := r.lines.lineAt(sp.start)
+	if err != nil {
+		return Chunk{}, nil, err
+	}
+	endLine := startLine
+	if n > 0 {
+		endLine += bytes.Count(text[:n-1], []byte("\n"))
+	}
+	return Chunk{
+		Index:      index,
+		Start:      sp.start,
+		End:        sp.end,
+		Tokens:     sp.tokens,
+		StartLine:  startLine,
+		EndLine:    endLine,
+		SHA256:     hashBytes(buf),
+		Breadcrumb: sp.breadcrumb,
+	}, buf, nil
+}
+
+func fileHasSize(path string, size int) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.Size() == int64(size)
+}
+
+// span is the source byte range of one chunk.
+type span struct {
+	start, end int64
+	tokens     int
+	breadcrumb string
+	// prefix and suffix are written around the source bytes.
+	prefix, suffix []byte
+}
+
+// planSpans calls fn with the byte range of every chunk of a file of the
+// given size, in order.
+func planSpans(f *os.File, size int64, opts Options, fn func(span) error) error {
+	switch opts.Strategy {
+	case StrategyMarkdown:
+		return planMarkdownSpans(f, opts, fn)
+	case StrategyCSV, StrategyJSONL, StrategyJSONArray:
+		return planRecordSpans(f, opts, fn)
+	case StrategyCDC:
+		return planCDCSpans(f, size, opts, fn)
+	}
+	if opts.Boundary != "" {
+		return planUnitSpans(f, size, opts, fn)
+	}
+	if opts.Unit == "tokens" {
+		return planTokenSpans(f, opts, fn)
+	}
 
-		// Stop if we reached the end.
-		pos, _ := f.Seek(0, io.SeekCurrent)
-		if pos >= st.Size() {
+	snap := func(off int64) (int64, error) {
+		if opts.Encoding == EncodingRaw {
+			return off, nil
+		}
+		return RuneStart(f, off, size)
+	}
+
+	step := int64(opts.Size - opts.Overlap)
+	for start := int64(0); start < size; {
+		end, err := snap(min(start+int64(opts.Size), size))
+		if err != nil {
+			return err
+		}
+		if end <= start {
+			// Size is smaller than the rune at start; take the whole rune.
+			if end, err = nextRuneStart(f, start, size); err != nil {
+				return err
+			}
+		}
+		if err := fn(span{start: start, end: end}); err != nil {
+			return err
+		}
+		if end >= size {
 			break
 		}
+		next, err := snap(start + step)
+		if err != nil {
+			return err
+		}
+		if next <= start {
+			next = end
+		}
+		start = next
+	}
+	return nil
+}
 
-		// Apply overlap by seeking backwards.
-		if opts.Overlap > 0 {
-			if _, err := f.Seek(int64(-opts.Overlap), io.SeekCurrent); err != nil {
-				return nil, err
+// planTokenSpans streams the file through the tokenizer and emits windows of
+// up to Size tokens, each starting Size-Overlap tokens after the previous
+// one. Tokens ending at the same offset (parts of one rune) are never split
+// between windows, which can make a window shorter; a rune of more than Size
+// tokens is a window of its own.
+func planTokenSpans(f *os.File, opts Options, fn func(span) error) error {
+	sg := newSegmenter(f, opts.Encoding)
+
+	var (
+		ends       []int64 // end offsets of tokens in the current window
+		chunkStart int64
+		emittedEnd int64
+	)
+	emit := func(n int) error {
+		sp := span{start: chunkStart, end: ends[n-1], tokens: n}
+		emittedEnd = sp.end
+		return fn(sp)
+	}
+
+	for {
+		seg, err := sg.next()
+		if err != nil {
+			return err
+		}
+		if seg == nil {
+			break
+		}
+		for _, e := range opts.Tokenizer.Split(seg) {
+			ends = append(ends, sg.pos+int64(e))
+			if len(ends) <= opts.Size {
+				continue
 			}
+			// The last token did not fit: end after the last token that
+			// ends before the one following it.
+			n := opts.Size
+			for n > 0 && ends[n-1] == ends[n] {
+				n--
+			}
+			if n == 0 {
+				for n < len(ends) && ends[n] == ends[0] {
+					n++
+				}
+				if n == len(ends) {
+					continue // the rune may have more tokens
+				}
+			}
+			if err := emit(n); err != nil {
+				return err
+			}
+			step := max(1, n-opts.Overlap)
+			for step < n && ends[step-1] == ends[step] {
+				step++
+			}
+			chunkStart = ends[step-1]
+			ends = append(ends[:0], ends[step:]...)
 		}
+	}
 
-		// Defensive: if step is 0 (shouldn't happen due to validation), avoid infinite loop.
-		_ = step
+	if len(ends) > 0 && ends[len(ends)-1] > emittedEnd {
+		return emit(len(ends))
 	}
+	return nil
+}
+
+const (
+	segmentBytes = 1 << 20
+	maxSegment   = 8 * segmentBytes
+)
 
-	return out, nil
+// segmenter reads a file sequentially in segments of about segmentBytes that
+// end at a newline, so that a segment boundary never falls inside a word and
+// memory use does not grow with the file size. A single line longer than
+// maxSegment is cut regardless; with EncodingUTF8 an incomplete trailing rune
+// is kept for the next segment.
+type segmenter struct {
+	r        *bufio.Reader
+	encoding string
+	// pos is the file offset of the segment last returned by next.
+	pos        int64
+	seg, carry []byte
+	eof        bool
+}
+
+func newSegmenter(f *os.File, encoding string) *segmenter {
+	return &segmenter{
+		r:        bufio.NewReaderSize(io.NewSectionReader(f, 0, 1<<62), segmentBytes),
+		encoding: encoding,
+	}
+}
+
+// next returns the following segment, or nil at the end of the file. The
+// returned slice is only valid until the next call.
+func (s *segmenter) next() ([]byte, error) {
+	s.pos += int64(len(s.seg))
+	s.seg = append(s.seg[:0], s.carry...)
+	s.carry = s.carry[:0]
+	for !s.eof {
+		frag, err := s.r.ReadSlice('\n')
+		s.seg = append(s.seg, frag...)
+		if err == io.EOF {
+			s.eof = true
+			break
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		if err == nil && len(s.seg) >= segmentBytes {
+			break
+		}
+		if len(s.seg) >= maxSegment {
+			if s.encoding == EncodingUTF8 {
+				k := runeCut(s.seg)
+				s.carry = append(s.carry, s.seg[k:]...)
+				s.seg = s.seg[:k]
+			}
+			break
+		}
+	}
+	if len(s.seg) == 0 {
+		return nil, nil
+	}
+	return s.seg, nil
 }
@@ -0,0 +1,661 @@
+package rlmchunk
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWriteChunks_Bytes(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(in, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := WriteChunks(Options{InPath: in, OutDir: filepath.Join(dir, "out"), Size: 4, Overlap: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := m.Chunks
+	want := []string{"0123", "3456", "6789"}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(chunks), len(want))
+	}
+	for i, c := range chunks {
+		b, err := os.ReadFile(c.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want[i] {
+			t.Errorf("chunk %d = %q, want %q", i, b, want[i])
+		}
+	}
+}
+
+func TestApproxTokenizer_UpperBound(t *testing.T) {
+	// Counts from the real cl100k_base tokenizer.
+	known := []struct {
+		text  string
+		cl100 int
+		exact bool // every piece is a known single token
+	}{
+		{"hello world", 2, true},
+		{"Hello world", 2, true},
+		{"The quick brown fox jumps over the lazy dog.", 10, true},
+		{"2024-01-15", 6, true},
+		{"tiktoken is great!", 6, false},
+	}
+	for _, tt := range known {
+		if n := len(ApproxTokenizer{}.Split([]byte(tt.text))); n < tt.cl100 || tt.exact && n != tt.cl100 {
+			t.Errorf("%q: %d tokens, cl100k has %d", tt.text, n, tt.cl100)
+		}
+	}
+
+	// Runs the estimate does not know cost one token per byte, which BPE
+	// cannot exceed, with the tokens of a rune all ending after it.
+	for _, text := range []string{"qZxJvKwPmTbY", "你好世界🙂", "ÄÖÜ"} {
+		ends := ApproxTokenizer{}.Split([]byte(text))
+		if len(ends) != len(text) {
+			t.Errorf("%q: %d tokens, want one per byte (%d)", text, len(ends), len(text))
+		}
+		for i, e := range ends {
+			if (i > 0 && e < ends[i-1]) || e < len(text) && !utf8.RuneStart(text[e]) {
+				t.Errorf("%q: token end %d at %d is out of order or inside a rune", text, i, e)
+			}
+		}
+	}
+
+	// A window never splits the tokens of one rune, so its count is that
+	// of its text.
+	dir := t.TempDir()
+	in := filepath.Join(dir, "cjk.txt")
+	if err := os.WriteFile(in, []byte(strings.Repeat("世界🙂 and more\n", 20)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []Options{
+		{Size: 7, Overlap: 2},
+		{Size: 7, Boundary: BoundaryLine},
+		{Size: 7, Strategy: StrategyMarkdown},
+		{Size: 2},
+	} {
+		opts.InPath, opts.Unit = in, "tokens"
+		err := Each(opts, func(c Chunk, b []byte) error {
+			n := len(ApproxTokenizer{}.Split(b))
+			if !utf8.Valid(b) || c.Tokens != 0 && c.Tokens != n || n > opts.Size && utf8.RuneCount(b) > 1 {
+				t.Errorf("%+v: chunk %d %q has %d tokens, reports %d", opts, c.Index, b, n, c.Tokens)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWriteChunks_TokensWithOverlap(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog.\n", 50)
+	if err := os.WriteFile(in, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	const size, overlap = 40, 5
+	m, err := WriteChunks(Options{InPath: in, OutDir: filepath.Join(dir, "out"), Size: size, Overlap: overlap, Unit: "tokens"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := m.Chunks
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want several", len(chunks))
+	}
+
+	tok := ApproxTokenizer{}
+	for i, c := range chunks {
+		b, err := os.ReadFile(c.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != text[c.Start:c.End] {
+			t.Errorf("chunk %d content does not match [%d,%d)", i, c.Start, c.End)
+		}
+		if c.Tokens > size || c.Tokens != len(tok.Split(b)) {
+			t.Errorf("chunk %d reports %d tokens, text has %d", i, c.Tokens, len(tok.Split(b)))
+		}
+		if i > 0 {
+			prev := chunks[i-1]
+			if c.Start >= prev.End {
+				t.Errorf("chunk %d starts at %d, want overlap with previous end %d", i, c.Start, prev.End)
+			}
+			if got := len(tok.Split([]byte(text[c.Start:prev.End]))); got != overlap {
+				t.Errorf("chunk %d overlaps previous by %d tokens, want %d", i, got, overlap)
+			}
+		}
+	}
+	if last := chunks[len(chunks)-1]; last.End != int64(len(text)) {
+		t.Errorf("last chunk ends at %d, want %d", last.End, len(text))
+	}
+}
+
+func TestWriteChunks_UTF8Boundaries(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	text := "añb€c😀d"
+	if err := os.WriteFile(in, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := WriteChunks(Options{InPath: in, OutDir: filepath.Join(dir, "utf8"), Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := m.Chunks
+	var joined strings.Builder
+	for i, c := range chunks {
+		b, err := os.ReadFile(c.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !utf8.Valid(b) {
+			t.Errorf("chunk %d [%d,%d) is not valid UTF-8: %q", i, c.Start, c.End, b)
+		}
+		joined.Write(b)
+	}
+	if joined.String() != text {
+		t.Errorf("chunks join to %q, want %q", joined.String(), text)
+	}
+
+	m, err = WriteChunks(Options{InPath: in, OutDir: filepath.Join(dir, "raw"), Size: 3, Encoding: EncodingRaw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks = m.Chunks
+	if c := chunks[0]; c.Start != 0 || c.End != 3 {
+		t.Errorf("raw chunk 0 = [%d,%d), want [0,3)", c.Start, c.End)
+	}
+}
+
+func TestWriteChunks_Boundaries(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	text := "One two. Three four five!\nSix seven.\n\nEight nine ten. Eleven.\n\n\nTwelve thirteen fourteen fifteen sixteen seventeen.\n"
+	if err := os.WriteFile(in, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(chunks []Chunk) []string {
+		t.Helper()
+		var out []string
+		for _, c := range chunks {
+			b, err := os.ReadFile(c.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, string(b))
+		}
+		return out
+	}
+
+	tests := []struct {
+		boundary      string
+		size, overlap int
+		want          []string
+	}{
+		{BoundaryLine, 40, 0, []string{
+			"One two. Three four five!\nSix seven.\n\n",
+			"Eight nine ten. Eleven.\n\n\n",
+			// A single unit larger than Size is cut.
+			"Twelve thirteen fourteen fifteen sixteen",
+			" seventeen.\n",
+		}},
+		{BoundaryParagraph, 64, 0, []string{
+			"One two. Three four five!\nSix seven.\n\nEight nine ten. Eleven.\n\n\n",
+			"Twelve thirteen fourteen fifteen sixteen seventeen.\n",
+		}},
+		{BoundarySentence, 30, 18, []string{
+			"One two. Three four five!\n",
+			"Three four five!\nSix seven.\n\n",
+			"Six seven.\n\nEight nine ten. ",
+			"\nEight nine ten. Eleven.\n\n\n",
+			"Twelve thirteen fourteen fifte",
+			"een fourteen fifteen sixteen s",
+			" fifteen sixteen seventeen.\n",
+		}},
+	}
+	for _, tt := range tests {
+		m, err := WriteChunks(Options{
+			InPath: in, OutDir: filepath.Join(dir, tt.boundary),
+			Size: tt.size, Overlap: tt.overlap, Boundary: tt.boundary,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks := m.Chunks
+		got := read(chunks)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.boundary, got, tt.want)
+		}
+	}
+}
+
+func TestWriteChunks_Markdown(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.md")
+	doc := "Preamble.\n\n" +
+		"# Guide\nIntro.\n\n" +
+		"## Install\nRun it.\n\n" +
+		"## Usage\nSome usage text.\n\n" +
+		"```sh\n# not a heading\n\nrlm chunk --strategy markdown\n```\n\n" +
+		"### Flags\nFlag text.\n"
+	if err := os.WriteFile(in, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := WriteChunks(Options{InPath: in, OutDir: filepath.Join(dir, "out"), Size: 48, Strategy: StrategyMarkdown})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := m.Chunks
+	type got struct{ Text, Breadcrumb string }
+	var gots []got
+	for _, c := range chunks {
+		b, err := os.ReadFile(c.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gots = append(gots, got{string(b), c.Breadcrumb})
+	}
+	want := []got{
+		{"Preamble.\n\n", ""},
+		{"# Guide\nIntro.\n\n## Install\nRun it.\n\n", "# Guide"},
+		{"## Usage\nSome usage text.\n\n", "# Guide > ## Usage"},
+		// The fence is larger than Size but stays whole.
+		{"```sh\n# not a heading\n\nrlm chunk --strategy markdown\n```\n\n", "# Guide > ## Usage"},
+		{"### Flags\nFlag text.\n", "# Guide > ## Usage > ### Flags"},
+	}
+	if !reflect.DeepEqual(gots, want) {
+		t.Errorf("got %q\nwant %q", gots, want)
+	}
+
+	// A document that fits in one chunk reports its opening heading.
+	titled := filepath.Join(dir, "titled.md")
+	if err := os.WriteFile(titled, []byte("# Title\nText.\n\n## Sub\nMore.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var crumbs []string
+	err = Each(Options{InPath: titled, Size: 3000, Strategy: StrategyMarkdown}, func(c Chunk, _ []byte) error {
+		crumbs = append(crumbs, c.Breadcrumb)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(crumbs, []string{"# Title"}) {
+		t.Errorf("single chunk breadcrumbs = %q, %v", crumbs, err)
+	}
+
+	// A Size smaller than a rune takes the whole rune.
+	emoji := filepath.Join(dir, "emoji.md")
+	if err := os.WriteFile(emoji, []byte("🙂é\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	err = Each(Options{InPath: emoji, Size: 1, Strategy: StrategyMarkdown}, func(_ Chunk, b []byte) error {
+		texts = append(texts, string(b))
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(texts, []string{"🙂", "é", "\n"}) {
+		t.Errorf("size 1 chunks = %q, %v", texts, err)
+	}
+}
+
+func TestWriteChunks_Records(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		strategy, input string
+		size            int
+		want            []string
+	}{
+		{StrategyCSV,
+			"id,note\n1,plain\n2,\"two\nlines\"\n3,x\n", 24,
+			[]string{"id,note\n1,plain\n", "id,note\n2,\"two\nlines\"\n", "id,note\n3,x\n"}},
+		{StrategyJSONL,
+			"{\"a\":1}\n{\"a\":2}\n{\"a\":3}\n", 16,
+			[]string{"{\"a\":1}\n{\"a\":2}\n", "{\"a\":3}\n"}},
+		{StrategyJSONArray,
+			"[ {\"s\": \"a],b\"}, [1, 2],\n  3, \"x\\\"y\" ]", 30,
+			[]string{"[\n{\"s\": \"a],b\"}, [1, 2]\n]\n", "[\n3, \"x\\\"y\"\n]\n"}},
+	}
+	for _, tt := range tests {
+		in := filepath.Join(dir, tt.strategy+".in")
+		if err := os.WriteFile(in, []byte(tt.input), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		m, err := WriteChunks(Options{InPath: in, OutDir: filepath.Join(dir, tt.strategy), Size: tt.size, Strategy: tt.strategy})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.strategy, err)
+		}
+		chunks := m.Chunks
+		var got []string
+		for _, c := range chunks {
+			b, err := os.ReadFile(c.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(b))
+			if tt.strategy == StrategyJSONArray && !json.Valid(b) {
+				t.Errorf("%s: chunk %d is not valid JSON: %q", tt.strategy, c.Index, b)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.strategy, got, tt.want)
+		}
+	}
+}
+
+func TestWriteChunks_Manifest(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(in, []byte("one\ntwo\nthree\nfour\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+
+	m, err := WriteChunks(Options{InPath: in, OutDir: out, Size: 12, Boundary: BoundaryLine})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Source.Size != 19 || m.Source.SHA256 != hashBytes([]byte("one\ntwo\nthree\nfour\n")) {
+		t.Errorf("source = %+v", m.Source)
+	}
+	if m.Params.Size != 12 || m.Params.Unit != "bytes" || m.Params.Boundary != BoundaryLine || m.Params.Strategy != StrategyFixed {
+		t.Errorf("params = %+v", m.Params)
+	}
+
+	type lines struct{ start, end int }
+	want := []lines{{1, 2}, {3, 4}}
+	if len(m.Chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(m.Chunks), len(want))
+	}
+	for i, c := range m.Chunks {
+		if got := (lines{c.StartLine, c.EndLine}); got != want[i] {
+			t.Errorf("chunk %d lines = %v, want %v", i, got, want[i])
+		}
+		b, err := os.ReadFile(c.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.SHA256 != hashBytes(b) {
+			t.Errorf("chunk %d hash does not match its file", i)
+		}
+	}
+
+	onDisk, err := ReadManifest(out, "chunk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(onDisk.Chunks, m.Chunks) || onDisk.Source.SHA256 != m.Source.SHA256 {
+		t.Errorf("manifest on disk differs from the returned one")
+	}
+}
+
+func TestWriteChunks_Incremental(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	out := filepath.Join(dir, "out")
+	write := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(in, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(force bool) Result {
+		t.Helper()
+		res, err := WriteChunks(Options{InPath: in, OutDir: out, Size: 4, Force: force})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	write("aaaabbbbcccc")
+	if res := run(false); res.UpToDate || res.Written != 3 {
+		t.Fatalf("first run: up_to_date=%v written=%d, want a full run", res.UpToDate, res.Written)
+	}
+	if res := run(false); !res.UpToDate {
+		t.Errorf("second run was not a no-op")
+	}
+
+	// Only the middle chunk changes; the last one disappears.
+	write("aaaaBBBB")
+	res := run(false)
+	if res.UpToDate || res.Written != 1 || res.Unchanged != 1 {
+		t.Errorf("after edit: written=%d unchanged=%d, want 1 and 1", res.Written, res.Unchanged)
+	}
+	stale := filepath.Join(out, "chunk_0002.txt")
+	if !reflect.DeepEqual(res.Removed, []string{stale}) {
+		t.Errorf("removed = %q, want %q", res.Removed, stale)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale chunk still exists: %v", err)
+	}
+
+	if res := run(true); res.UpToDate || res.Written != 2 {
+		t.Errorf("forced run: up_to_date=%v written=%d, want 2 written", res.UpToDate, res.Written)
+	}
+}
+
+func TestWriteChunks_SharedOutDir(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	run := func(name, text, prefix string) Result {
+		t.Helper()
+		in := filepath.Join(dir, name)
+		if err := os.WriteFile(in, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		res, err := WriteChunks(Options{InPath: in, OutDir: out, Size: 4, Prefix: prefix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	a := run("a.txt", "aaaabbbbcccc", "a")
+	b := run("b.txt", "dddd", "b")
+	if len(b.Removed) != 0 {
+		t.Errorf("chunking b removed %q", b.Removed)
+	}
+	for _, c := range a.Chunks {
+		if _, err := os.Stat(c.Path); err != nil {
+			t.Errorf("chunk of a is gone: %v", err)
+		}
+	}
+
+	// Each source keeps its own manifest, so both stay up to date.
+	if res := run("a.txt", "aaaabbbbcccc", "a"); !res.UpToDate {
+		t.Errorf("a was chunked again after b shared its directory")
+	}
+	if n, err := Count(Options{InPath: filepath.Join(dir, "b.txt"), OutDir: out, Size: 4, Prefix: "b"}); err != nil || n != 1 {
+		t.Errorf("count of b = %d, %v", n, err)
+	}
+
+	// Another source under the same prefix takes over its chunk names, so
+	// the chunks of a it does not overwrite are removed, not orphaned.
+	c := run("c.txt", "eeee", "a")
+	stale := []string{filepath.Join(out, "a_0001.txt"), filepath.Join(out, "a_0002.txt")}
+	if !reflect.DeepEqual(c.Removed, stale) {
+		t.Errorf("chunking c removed %q, want %q", c.Removed, stale)
+	}
+	for _, p := range stale {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("chunk of a left behind: %s", p)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "b_0000.txt")); err != nil {
+		t.Errorf("chunk of b was removed: %v", err)
+	}
+}
+
+func TestWriteChunks_CDCStableAcrossEdits(t *testing.T) {
+	dir := t.TempDir()
+	words := strings.Fields("alpha beta gamma delta epsilon zeta eta theta iota kappa lambda mu nu xi omicron pi rho sigma tau")
+	var sb strings.Builder
+	x := uint32(1)
+	for sb.Len() < 200_000 {
+		x = x*1664525 + 1013904223
+		sb.WriteString(words[x>>16%uint32(len(words))])
+		if x%11 == 0 {
+			sb.WriteString(".\n")
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	orig := sb.String()
+	edited := orig[:1000] + "An inserted paragraph that shifts everything after it.\n" + orig[1000:]
+
+	hashes := func(name, text string) map[string]bool {
+		t.Helper()
+		in := filepath.Join(dir, name)
+		if err := os.WriteFile(in, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		res, err := WriteChunks(Options{InPath: in, OutDir: filepath.Join(dir, name+".out"), Size: 4096, Strategy: StrategyCDC})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := map[string]bool{}
+		for _, c := range res.Chunks {
+			if n := c.End - c.Start; n > 4*4096 || (n < 1024 && c.End != int64(len(text))) {
+				t.Errorf("%s: chunk %d has %d bytes, outside [min, max]", name, c.Index, n)
+			}
+			out[c.SHA256] = true
+		}
+		return out
+	}
+	before, after := hashes("orig", orig), hashes("edited", edited)
+	shared := 0
+	for h := range after {
+		if before[h] {
+			shared++
+		}
+	}
+	if shared < len(after)-2 {
+		t.Errorf("only %d of %d chunks survived a small edit", shared, len(after))
+	}
+}
+
+func TestWriteAll_OrderAndErrors(t *testing.T) {
+	dir := t.TempDir()
+	var jobs []Options
+	for _, name := range []string{"a.txt", "missing.txt", "b.txt"} {
+		in := filepath.Join(dir, name)
+		if name != "missing.txt" {
+			if err := os.WriteFile(in, []byte(strings.Repeat(name, 10)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		jobs = append(jobs, Options{InPath: in, OutDir: filepath.Join(dir, "out", name), Size: 16})
+	}
+
+	outcomes := WriteAll(jobs, 2)
+	if len(outcomes) != 3 {
+		t.Fatalf("got %d outcomes, want 3", len(outcomes))
+	}
+	if outcomes[1].Err == nil {
+		t.Errorf("missing file did not fail")
+	}
+	for _, i := range []int{0, 2} {
+		o := outcomes[i]
+		if o.Err != nil || o.Source.Path != jobs[i].InPath || len(o.Chunks) != 4 {
+			t.Errorf("outcome %d = %+v, err %v", i, o.Source, o.Err)
+		}
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(in, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := WriteNDJSON(&out, Options{InPath: in, Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	var got []Record
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var r Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("bad line %q: %v", line, err)
+		}
+		got = append(got, r)
+	}
+	want := []string{"0123", "4567", "89"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d", len(got), len(want))
+	}
+	for i, r := range got {
+		if r.Index != i || r.Text != want[i] || r.Start != int64(4*i) || r.Path != "" {
+			t.Errorf("record %d = %+v", i, r)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("streaming wrote files: %v", entries)
+	}
+}
+
+func TestGetAndCount(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(in, []byte("id,name\n1,a\n2,b\n3,c\n4,d\n5,e\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{InPath: in, Size: 16, Strategy: StrategyCSV}
+
+	var all []Record
+	if err := Each(opts, func(c Chunk, text []byte) error {
+		all = append(all, Record{Chunk: c, Text: string(text)})
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(opts Options) {
+		t.Helper()
+		n, err := Count(opts)
+		if err != nil || n != len(all) {
+			t.Fatalf("Count = %d, %v; want %d", n, err, len(all))
+		}
+		for i, want := range all {
+			c, text, err := Get(opts, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Path = ""
+			if c != want.Chunk || string(text) != want.Text {
+				t.Errorf("Get(%d) = %+v %q, want %+v %q", i, c, text, want.Chunk, want.Text)
+			}
+		}
+		if _, _, err := Get(opts, len(all)); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("Get past the end: %v", err)
+		}
+	}
+	check(opts)
+
+	// With a current manifest the chunk files are used.
+	opts.OutDir = filepath.Join(dir, "out")
+	if _, err := WriteChunks(opts); err != nil {
+		t.Fatal(err)
+	}
+	check(opts)
+	if err := os.WriteFile(filepath.Join(opts.OutDir, "chunk_0000.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, text, _ := Get(opts, 0); string(text) != "x" {
+		t.Errorf("Get did not read the chunk file: %q", text)
+	}
+}
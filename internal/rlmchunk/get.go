@@ -0,0 +1,122 @@
+package rlmchunk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errFound stops planning once the requested chunk has been read.
+var errFound = errors.New("chunk found")
+
+// Get returns chunk index of opts.InPath and its content, as WriteChunks
+// would write it, without producing the other chunks. If opts.OutDir holds
+// a manifest for the same source and parameters, the chunk is read from
+// there; otherwise chunks are planned up to index and only that one is read.
+func Get(opts Options, index int) (Chunk, []byte, error) {
+	opts, err := normalize(opts)
+	if err != nil {
+		return Chunk{}, nil, err
+	}
+	if index < 0 {
+		return Chunk{}, nil, fmt.Errorf("index must be >= 0")
+	}
+	f, err := os.Open(opts.InPath)
+	if err != nil {
+		return Chunk{}, nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return Chunk{}, nil, err
+	}
+
+	if m, ok := currentManifest(opts, st); ok {
+		if index >= len(m.Chunks) {
+			return Chunk{}, nil, outOfRange(opts, index, len(m.Chunks))
+		}
+		c := m.Chunks[index]
+		text, err := os.ReadFile(c.Path)
+		if err != nil {
+			return Chunk{}, nil, err
+		}
+		return c, text, nil
+	}
+
+	var (
+		c    Chunk
+		text []byte
+		n    int
+	)
+	cr := newChunkReader(f, opts)
+	err = planSpans(f, st.Size(), opts, func(sp span) error {
+		if n < index {
+			n++
+			return nil
+		}
+		var err error
+		if c, text, err = cr.read(sp, index); err != nil {
+			return err
+		}
+		return errFound
+	})
+	switch {
+	case err == errFound:
+		return c, text, nil
+	case err != nil:
+		return Chunk{}, nil, err
+	}
+	return Chunk{}, nil, outOfRange(opts, index, n)
+}
+
+// Count returns the number of chunks of opts.InPath, from the manifest in
+// opts.OutDir when it is current, otherwise by planning them without
+// reading their content.
+func Count(opts Options) (int, error) {
+	opts, err := normalize(opts)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(opts.InPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if m, ok := currentManifest(opts, st); ok {
+		return len(m.Chunks), nil
+	}
+
+	n := 0
+	err = planSpans(f, st.Size(), opts, func(span) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// currentManifest returns the manifest of opts.Prefix in opts.OutDir if it
+// was written for the source as it is now, with the same parameters.
+func currentManifest(opts Options, st os.FileInfo) (Manifest, bool) {
+	if opts.OutDir == "" {
+		return Manifest{}, false
+	}
+	m, err := ReadManifest(opts.OutDir, opts.Prefix)
+	if err != nil {
+		return Manifest{}, false
+	}
+	src := Source{Path: opts.InPath, Size: st.Size()}
+	if !m.sameRun(src, paramsOf(opts)) || !m.Source.ModTime.Equal(st.ModTime()) {
+		return Manifest{}, false
+	}
+	return m, true
+}
+
+func outOfRange(opts Options, index, n int) error {
+	return fmt.Errorf("chunk %d out of range: %s has %d chunks", index, opts.InPath, n)
+}
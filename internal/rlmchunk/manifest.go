@@ -0,0 +1,148 @@
+package rlmchunk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestPath returns where WriteChunks keeps the manifest of the chunks
+// named after prefix in outDir. Keying it by prefix lets several sources
+// share an output directory, as their chunk files do.
+func ManifestPath(outDir, prefix string) string {
+	return filepath.Join(outDir, prefix+".manifest.json")
+}
+
+const manifestVersion = 1
+
+// Manifest describes a chunk run: the source it was cut from, the options
+// used and where every chunk came from.
+type Manifest struct {
+	Version int     `json:"version"`
+	Source  Source  `json:"source"`
+	Params  Params  `json:"params"`
+	Chunks  []Chunk `json:"chunks"`
+}
+
+type Source struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// Params are the chunking options after defaults are applied.
+type Params struct {
+	Size     int    `json:"size"`
+	Overlap  int    `json:"overlap"`
+	Unit     string `json:"unit"`
+	Encoding string `json:"encoding"`
+	Boundary string `json:"boundary,omitempty"`
+	Strategy string `json:"strategy"`
+	MinSize  int    `json:"min_size,omitempty"`
+	MaxSize  int    `json:"max_size,omitempty"`
+	Prefix   string `json:"prefix"`
+}
+
+func paramsOf(opts Options) Params {
+	return Params{
+		Size:     opts.Size,
+		Overlap:  opts.Overlap,
+		Unit:     opts.Unit,
+		Encoding: opts.Encoding,
+		Boundary: opts.Boundary,
+		Strategy: opts.Strategy,
+		MinSize:  opts.MinSize,
+		MaxSize:  opts.MaxSize,
+		Prefix:   opts.Prefix,
+	}
+}
+
+// sameRun reports whether m was produced from the source at src's path
+// with the same size and parameters, and its chunk files are all present.
+func (m Manifest) sameRun(src Source, params Params) bool {
+	if m.Version != manifestVersion || m.Source.Path != src.Path || m.Source.Size != src.Size || m.Params != params {
+		return false
+	}
+	for _, c := range m.Chunks {
+		if _, err := os.Stat(c.Path); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadManifest reads the manifest of the chunks named after prefix in
+// outDir.
+func ReadManifest(outDir, prefix string) (Manifest, error) {
+	var m Manifest
+	b, err := os.ReadFile(ManifestPath(outDir, prefix))
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+func writeManifest(outDir string, m Manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := ManifestPath(outDir, m.Params.Prefix)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// lineCounter maps offsets of a file to 1-based line numbers. It is fastest
+// when called with non-decreasing offsets.
+type lineCounter struct {
+	r    io.ReaderAt
+	off  int64
+	line int
+	buf  []byte
+}
+
+func newLineCounter(r io.ReaderAt) *lineCounter {
+	return &lineCounter{r: r, line: 1, buf: make([]byte, 64*1024)}
+}
+
+func (c *lineCounter) lineAt(off int64) (int, error) {
+	if off < c.off {
+		c.off, c.line = 0, 1
+	}
+	for c.off < off {
+		n := min(int64(len(c.buf)), off-c.off)
+		k, err := c.r.ReadAt(c.buf[:n], c.off)
+		c.line += bytes.Count(c.buf[:k], []byte("\n"))
+		c.off += int64(k)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return c.line, nil
+}
@@ -0,0 +1,350 @@
+package rlmchunk
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// mdSection is a heading and the content up to the next heading of any
+// level. The preamble before the first heading is a level 0 section.
+type mdSection struct {
+	level    int
+	heading  string // normalized heading line, e.g. "## Install"
+	start    int64
+	ownEnd   int64   // start of the next heading
+	end      int64   // end of the last descendant
+	blocks   []int64 // block ends in [start, ownEnd)
+	fenced   []bool  // whether each block is a fenced code block
+	children []*mdSection
+}
+
+// planMarkdownSpans splits a Markdown document along its heading hierarchy.
+// A section that fits in Size is never split; consecutive small sibling
+// sections (and a parent's introduction before them) are merged up to Size.
+// Larger sections are split into their children, and text that still does
+// not fit is split between paragraphs, then lines. Fenced code blocks are
+// never split, even when larger than Size. Each chunk carries the breadcrumb
+// of the section it starts in. The document is read into memory.
+func planMarkdownSpans(f *os.File, opts Options, fn func(span) error) error {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	m := &mdPlanner{data: data, opts: opts}
+	if opts.Unit == "tokens" {
+		m.tokenEnds = opts.Tokenizer.Split(data)
+	}
+	root := parseMarkdown(data)
+	for _, p := range m.section(root, nil) {
+		sp := span{start: p.start, end: p.end, breadcrumb: p.breadcrumb}
+		if opts.Unit == "tokens" {
+			sp.tokens = int(p.cost)
+		}
+		if err := fn(sp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseMarkdown returns the root of the section tree. Only ATX headings
+// ("# Title") outside fenced code blocks start sections. Within a section,
+// blocks end where a paragraph starts after blank lines and around fenced
+// code blocks.
+func parseMarkdown(data []byte) *mdSection {
+	root := &mdSection{}
+	stack := []*mdSection{root}
+	cur := root
+
+	closeBlock := func(at int64, fenced bool) {
+		last := cur.start
+		if n := len(cur.blocks); n > 0 {
+			last = cur.blocks[n-1]
+		}
+		if at > last {
+			cur.blocks = append(cur.blocks, at)
+			cur.fenced = append(cur.fenced, fenced)
+		}
+	}
+
+	var (
+		fenceChar byte
+		fenceLen  int
+		prevBlank bool
+	)
+	for pos := 0; pos < len(data); {
+		lineEnd := len(data)
+		if i := bytes.IndexByte(data[pos:], '\n'); i >= 0 {
+			lineEnd = pos + i + 1
+		}
+		line := data[pos:lineEnd]
+		start, end := int64(pos), int64(lineEnd)
+		pos = lineEnd
+
+		if fenceChar != 0 {
+			if c, n, rest := fenceMarker(line); c == fenceChar && n >= fenceLen && len(bytes.TrimSpace(rest)) == 0 {
+				fenceChar = 0
+				closeBlock(end, true)
+				prevBlank = false
+			}
+			continue
+		}
+		if c, n, _ := fenceMarker(line); c != 0 {
+			closeBlock(start, false)
+			fenceChar, fenceLen = c, n
+			continue
+		}
+		if level, heading, ok := atxHeading(line); ok {
+			closeBlock(start, false)
+			cur.ownEnd = start
+			for len(stack) > 1 && stack[len(stack)-1].level >= level {
+				stack = stack[:len(stack)-1]
+			}
+			cur = &mdSection{level: level, heading: heading, start: start}
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, cur)
+			stack = append(stack, cur)
+			prevBlank = false
+			continue
+		}
+		// Blank lines stay with the block they end.
+		blank := len(bytes.TrimSpace(line)) == 0
+		if n := len(cur.blocks); blank && n > 0 && cur.blocks[n-1] == start {
+			cur.blocks[n-1] = end
+		} else if !blank && prevBlank {
+			closeBlock(start, false)
+		}
+		prevBlank = blank
+	}
+
+	size := int64(len(data))
+	// An unterminated fence runs to the end of the document.
+	closeBlock(size, fenceChar != 0)
+	cur.ownEnd = size
+	setEnds(root, size)
+	return root
+}
+
+// setEnds fills in end for s and its descendants; end is the end of s's
+// last descendant, or its own end.
+func setEnds(s *mdSection, end int64) {
+	s.end = end
+	for i, c := range s.children {
+		next := end
+		if i+1 < len(s.children) {
+			next = s.children[i+1].start
+		}
+		setEnds(c, next)
+	}
+}
+
+// fenceMarker reports the fence character and length when line opens or
+// closes a fenced code block, with whatever follows the fence.
+func fenceMarker(line []byte) (byte, int, []byte) {
+	i := 0
+	for i < len(line) && i < 3 && line[i] == ' ' {
+		i++
+	}
+	if i == len(line) || (line[i] != '`' && line[i] != '~') {
+		return 0, 0, nil
+	}
+	c := line[i]
+	n := 0
+	for i+n < len(line) && line[i+n] == c {
+		n++
+	}
+	if n < 3 {
+		return 0, 0, nil
+	}
+	rest := line[i+n:]
+	if c == '`' && bytes.IndexByte(rest, '`') >= 0 {
+		return 0, 0, nil
+	}
+	return c, n, rest
+}
+
+// atxHeading parses an ATX heading line, returning its level and the
+// heading normalized to "#... Title".
+func atxHeading(line []byte) (int, string, bool) {
+	i := 0
+	for i < len(line) && i < 3 && line[i] == ' ' {
+		i++
+	}
+	n := 0
+	for i+n < len(line) && line[i+n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 {
+		return 0, "", false
+	}
+	rest := line[i+n:]
+	if len(rest) > 0 && rest[0] != ' ' && rest[0] != '\t' && rest[0] != '\n' && rest[0] != '\r' {
+		return 0, "", false
+	}
+	title := strings.TrimSpace(string(rest))
+	// Drop an optional closing sequence of '#'.
+	if t := strings.TrimRight(title, "#"); t == "" || strings.HasSuffix(t, " ") || strings.HasSuffix(t, "\t") {
+		title = strings.TrimSpace(t)
+	}
+	heading := strings.Repeat("#", n)
+	if title != "" {
+		heading += " " + title
+	}
+	return n, heading, true
+}
+
+type mdPlanner struct {
+	data      []byte
+	opts      Options
+	tokenEnds []int
+}
+
+// mdPiece is a planned chunk. Pieces whose merge flag is set may be joined
+// with adjacent mergeable pieces of the same level.
+type mdPiece struct {
+	start, end int64
+	cost       int64
+	breadcrumb string
+	merge      bool
+}
+
+// cost returns the size of [start, end) in Options.Unit.
+func (m *mdPlanner) cost(start, end int64) int64 {
+	if m.tokenEnds == nil {
+		return end - start
+	}
+	lo := sort.SearchInts(m.tokenEnds, int(start)+1)
+	hi := sort.SearchInts(m.tokenEnds, int(end)+1)
+	return int64(hi - lo)
+}
+
+func (m *mdPlanner) section(s *mdSection, crumbs []string) []mdPiece {
+	if s.level > 0 {
+		crumbs = append(crumbs[:len(crumbs):len(crumbs)], s.heading)
+	}
+	crumb := strings.Join(crumbs, " > ")
+	limit := int64(m.opts.Size)
+
+	if c := m.cost(s.start, s.end); c <= limit {
+		if c == 0 {
+			return nil
+		}
+		// The breadcrumb is that of the heading at the chunk start, which
+		// for a preamble-less root is its first subsection's.
+		for first := s; first.ownEnd == first.start && len(first.children) > 0; {
+			first = first.children[0]
+			crumbs = append(crumbs[:len(crumbs):len(crumbs)], first.heading)
+			crumb = strings.Join(crumbs, " > ")
+		}
+		return []mdPiece{{start: s.start, end: s.end, cost: c, breadcrumb: crumb, merge: true}}
+	}
+
+	// The introduction before the first subsection, then each child: whole
+	// children may merge with each other and with the introduction.
+	pieces := m.blocks(s, crumb)
+	for _, c := range s.children {
+		sub := m.section(c, crumbs)
+		if len(sub) > 1 {
+			for i := range sub {
+				sub[i].merge = false
+			}
+		}
+		pieces = append(pieces, sub...)
+	}
+	return m.mergePieces(pieces)
+}
+
+// blocks splits the section's own content, packing its blocks up to Size.
+func (m *mdPlanner) blocks(s *mdSection, crumb string) []mdPiece {
+	var pieces []mdPiece
+	start := s.start
+	for i, end := range s.blocks {
+		if end <= start {
+			continue
+		}
+		if !s.fenced[i] && m.cost(start, end) > int64(m.opts.Size) {
+			pieces = append(pieces, m.splitText(start, end, crumb)...)
+		} else {
+			pieces = append(pieces, mdPiece{start: start, end: end, cost: m.cost(start, end), breadcrumb: crumb, merge: true})
+		}
+		start = end
+	}
+	if start < s.ownEnd {
+		pieces = append(pieces, m.splitText(start, s.ownEnd, crumb)...)
+	}
+	return m.mergePieces(pieces)
+}
+
+// splitText splits an oversized block between lines, cutting inside a line
+// only when the line alone exceeds Size.
+func (m *mdPlanner) splitText(start, end int64, crumb string) []mdPiece {
+	var pieces []mdPiece
+	for pos := start; pos < end; {
+		lineEnd := end
+		if i := bytes.IndexByte(m.data[pos:end], '\n'); i >= 0 {
+			lineEnd = pos + int64(i) + 1
+		}
+		for pos < lineEnd {
+			cut := m.hardCut(pos, lineEnd)
+			pieces = append(pieces, mdPiece{start: pos, end: cut, cost: m.cost(pos, cut), breadcrumb: crumb, merge: true})
+			pos = cut
+		}
+	}
+	return m.mergePieces(pieces)
+}
+
+// hardCut returns the furthest offset in (start, end] within Size of start.
+func (m *mdPlanner) hardCut(start, end int64) int64 {
+	limit := int64(m.opts.Size)
+	if m.cost(start, end) <= limit {
+		return end
+	}
+	if m.tokenEnds != nil {
+		lo := sort.SearchInts(m.tokenEnds, int(start)+1)
+		// Do not cut between tokens that end at the same offset; a rune of
+		// more than Size tokens is taken whole.
+		i := lo + int(limit) - 1
+		for i >= lo && m.tokenEnds[i] == m.tokenEnds[i+1] {
+			i--
+		}
+		if i < lo {
+			i = lo
+		}
+		return int64(m.tokenEnds[i])
+	}
+	cut := start + limit
+	if m.opts.Encoding == EncodingUTF8 {
+		for cut > start && !utf8.RuneStart(m.data[cut]) {
+			cut--
+		}
+		if cut == start {
+			// Size is smaller than the rune at start; take the whole rune.
+			cut, _ = nextRuneStart(bytes.NewReader(m.data), start, end)
+		}
+	}
+	return cut
+}
+
+// mergePieces greedily joins runs of adjacent mergeable pieces up to Size.
+func (m *mdPlanner) mergePieces(pieces []mdPiece) []mdPiece {
+	var out []mdPiece
+	for _, p := range pieces {
+		if n := len(out); n > 0 && out[n-1].merge && p.merge {
+			if c := m.cost(out[n-1].start, p.end); c <= int64(m.opts.Size) {
+				out[n-1].end = p.end
+				out[n-1].cost = c
+				continue
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,254 @@
+package rlmchunk
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// recordReader returns consecutive records of a file with their byte range.
+// It returns io.EOF after the last record; rec is only valid until the next
+// call.
+type recordReader interface {
+	next() (start, end int64, rec []byte, err error)
+}
+
+// planRecordSpans packs whole records into chunks of at most Size, with a
+// prefix and suffix that make each chunk parseable on its own: the header
+// row for CSV and the enclosing brackets for a JSON array. Records are never
+// split; one larger than Size gets a chunk of its own. Spans cover the
+// records only.
+func planRecordSpans(f *os.File, opts Options, fn func(span) error) error {
+	r := bufio.NewReaderSize(io.NewSectionReader(f, 0, 1<<62), 1<<16)
+
+	var (
+		rr             recordReader
+		prefix, suffix []byte
+	)
+	switch opts.Strategy {
+	case StrategyCSV:
+		cr := &lineRecords{r: r, csv: true}
+		_, _, header, err := cr.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		prefix = append([]byte(nil), header...)
+		if !bytes.HasSuffix(prefix, []byte("\n")) {
+			prefix = append(prefix, '\n')
+		}
+		rr = cr
+	case StrategyJSONL:
+		rr = &lineRecords{r: r}
+	case StrategyJSONArray:
+		ar := &arrayRecords{r: r}
+		if err := ar.open(); err != nil {
+			return err
+		}
+		rr = ar
+		prefix, suffix = []byte("[\n"), []byte("\n]\n")
+	}
+
+	cost := func(b []byte) int64 {
+		if opts.Unit == "tokens" {
+			return int64(len(opts.Tokenizer.Split(b)))
+		}
+		return int64(len(b))
+	}
+	overhead := cost(prefix) + cost(suffix)
+	limit := int64(opts.Size)
+
+	var (
+		cur   span
+		total int64 // size of the records in cur, separators included
+		open  bool
+	)
+	flush := func() error {
+		if !open {
+			return nil
+		}
+		open = false
+		if opts.Unit == "tokens" {
+			cur.tokens = int(overhead + total)
+		}
+		return fn(cur)
+	}
+	for {
+		start, end, rec, err := rr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		c := cost(rec)
+		if opts.Unit != "tokens" && open {
+			// Bytes between records (JSON array separators) are kept.
+			c = end - cur.end
+		}
+		if open && overhead+total+c > limit {
+			if err := flush(); err != nil {
+				return err
+			}
+			c = cost(rec)
+		}
+		if !open {
+			cur = span{start: start, end: end, prefix: prefix, suffix: suffix}
+			total, open = c, true
+			continue
+		}
+		cur.end = end
+		total += c
+	}
+	return flush()
+}
+
+// lineRecords reads newline-terminated records. With csv set, newlines
+// inside double-quoted fields do not end a record.
+type lineRecords struct {
+	r   *bufio.Reader
+	csv bool
+	pos int64
+	buf []byte
+}
+
+func (l *lineRecords) next() (int64, int64, []byte, error) {
+	l.buf = l.buf[:0]
+	quotes := 0
+	for {
+		frag, err := l.r.ReadSlice('\n')
+		l.buf = append(l.buf, frag...)
+		if l.csv {
+			quotes += bytes.Count(frag, []byte(`"`))
+		}
+		if err == bufio.ErrBufferFull || err == nil && quotes%2 == 1 {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return 0, 0, nil, err
+		}
+		if len(l.buf) == 0 {
+			return 0, 0, nil, io.EOF
+		}
+		break
+	}
+	start := l.pos
+	l.pos += int64(len(l.buf))
+	return start, l.pos, l.buf, nil
+}
+
+// arrayRecords reads the elements of a top-level JSON array. Records span
+// the element value only, without surrounding whitespace or commas.
+type arrayRecords struct {
+	r    *bufio.Reader
+	pos  int64
+	buf  []byte
+	done bool
+}
+
+func (a *arrayRecords) readByte() (byte, error) {
+	c, err := a.r.ReadByte()
+	if err == nil {
+		a.pos++
+	}
+	return c, err
+}
+
+// skipSpace returns the next non-whitespace byte.
+func (a *arrayRecords) skipSpace() (byte, error) {
+	for {
+		c, err := a.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if !isSpaceByte(c) {
+			return c, nil
+		}
+	}
+}
+
+func (a *arrayRecords) open() error {
+	c, err := a.skipSpace()
+	if err == io.EOF {
+		a.done = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if c != '[' {
+		return fmt.Errorf("json-array: top-level value is not an array")
+	}
+	return nil
+}
+
+func (a *arrayRecords) next() (int64, int64, []byte, error) {
+	if a.done {
+		return 0, 0, nil, io.EOF
+	}
+	c, err := a.skipSpace()
+	if err != nil {
+		return 0, 0, nil, unexpectedEOF(err)
+	}
+	if c == ',' {
+		if c, err = a.skipSpace(); err != nil {
+			return 0, 0, nil, unexpectedEOF(err)
+		}
+	}
+	if c == ']' {
+		a.done = true
+		return 0, 0, nil, io.EOF
+	}
+
+	start := a.pos - 1
+	a.buf = append(a.buf[:0], c)
+	depth, inString, escaped := 0, c == '"', false
+	if c == '[' || c == '{' {
+		depth = 1
+	}
+	for inString || depth > 0 {
+		if c, err = a.readByte(); err != nil {
+			return 0, 0, nil, unexpectedEOF(err)
+		}
+		a.buf = append(a.buf, c)
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			escaped = c == '\\'
+			inString = c != '"'
+		case c == '"':
+			inString = true
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	if c := a.buf[0]; c != '"' && c != '[' && c != '{' {
+		// A scalar runs up to the next separator.
+		for {
+			b, err := a.r.Peek(1)
+			if err != nil {
+				return 0, 0, nil, unexpectedEOF(err)
+			}
+			if b[0] == ',' || b[0] == ']' || isSpaceByte(b[0]) {
+				break
+			}
+			c, _ := a.readByte()
+			a.buf = append(a.buf, c)
+		}
+	}
+	return start, a.pos, a.buf, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return fmt.Errorf("json-array: unexpected end of input")
+	}
+	return err
+}
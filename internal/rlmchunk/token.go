@@ -0,0 +1,225 @@
+package rlmchunk
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer splits text into model tokens.
+type Tokenizer interface {
+	// Split returns the end offset of every token in text, in
+	// non-decreasing order: tokens that share a rune all end after it, so
+	// an offset repeats. For non-empty text the last offset is len(text).
+	Split(text []byte) []int
+}
+
+// ApproxTokenizer counts an upper bound on the tokens of the cl100k_base BPE
+// used by recent OpenAI models, offline and without its vocabulary, so that
+// chunks sized in its tokens fit a model window. It reproduces cl100k's
+// pre-tokenization (words with their leading space, 1-3 digit groups,
+// punctuation runs, whitespace and newline runs, English contractions). BPE
+// never merges across those pieces and every token holds at least one byte,
+// so a piece counts its byte length, unless it is known to be a single
+// token: an ASCII digit group or one of singleTokens. Counts therefore run
+// above the real ones for text outside that list.
+type ApproxTokenizer struct{}
+
+func (ApproxTokenizer) Split(text []byte) []int {
+	var out []int
+	for i := 0; i < len(text); {
+		n := nextPiece(text[i:])
+		out = appendPieceTokens(out, text[i:i+n], i)
+		i += n
+	}
+	return out
+}
+
+// singleTokens holds pieces that cl100k encodes as one token: common English
+// words with their leading space, the most common ones also without it and
+// capitalized, and frequent whitespace runs.
+var singleTokens = func() map[string]bool {
+	spaced := strings.Fields(`
+		a about above after again against all also always an and another any
+		are around as at away back be because been before being below best
+		better between both but by call called can case change child children
+		city class close code come common could country course data day days
+		did different do does done down during each early end even every example
+		fact family far few file find first following for form found free from
+		full function general get give given go going good great group had
+		hand has have he head help her here high him his home house how however
+		if important in information into is it its just keep kind know large
+		last later left less let level life light like line list little local
+		long look made make man many may me mean might more most much must my
+		name need never new next no not nothing now number of off often old on
+		once one only open or order other our out over own page part people
+		place point possible power problem public quick put read real really
+		return right road room run said same say second section see set several
+		she should show side since small so some something start state still
+		string such system take test text than that the their them then there
+		these they thing things think this those though three through time to
+		together too true two type under until up upon us use used using value
+		very want was water way we well were what when where whether which while
+		who why will with within without word work world would year years yet
+		you young your brown fox jumps lazy dog over
+		false null none else import self def static void int const var func
+		package shall`)
+	bare := strings.Fields(`
+		a an and are as at be but by can do for from get has have he hello if
+		in is it me my name new no not of on one or out set so that the their
+		they this to two type up us use value was we which will with you
+		return import def class self function public static void int const var
+		let func package true false null none else while string list data text
+		code end line`)
+	capital := strings.Fields(`a i in it the this hello`)
+
+	m := map[string]bool{
+		"'s": true, "'t": true, "'re": true, "'ve": true, "'m": true, "'ll": true, "'d": true,
+		"\n\n": true, "  ": true, "    ": true,
+	}
+	for _, w := range spaced {
+		m[" "+w] = true
+	}
+	for _, w := range bare {
+		m[w] = true
+	}
+	for _, w := range capital {
+		w = strings.ToUpper(w[:1]) + w[1:]
+		m[w] = true
+		m[" "+w] = true
+	}
+	return m
+}()
+
+// nextPiece returns the length of the pre-tokenization piece at the start of
+// b, following the cl100k split pattern:
+//
+//	's|'t|'re|'ve|'m|'ll|'d | [^\r\n\p{L}\p{N}]?\p{L}+ | \p{N}{1,3} |
+//	 ?[^\s\p{L}\p{N}]+[\r\n]* | \s*[\r\n]+ | \s+(?!\S) | \s+
+func nextPiece(b []byte) int {
+	r, w := utf8.DecodeRune(b)
+
+	if r == '\'' {
+		if n := contraction(b[w:]); n > 0 {
+			return w + n
+		}
+	}
+
+	// Optional single non-letter, non-digit, non-newline prefix to a word.
+	if !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\r' && r != '\n' {
+		if n := runLen(b[w:], unicode.IsLetter, -1); n > 0 {
+			return w + n
+		}
+	}
+	if unicode.IsLetter(r) {
+		return runLen(b, unicode.IsLetter, -1)
+	}
+	if unicode.IsNumber(r) {
+		return runLen(b, unicode.IsNumber, 3)
+	}
+
+	if !unicode.IsSpace(r) || r == ' ' {
+		start := 0
+		if r == ' ' {
+			start = w
+		}
+		if n := runLen(b[start:], isPunct, -1); n > 0 {
+			return start + n + runLen(b[start+n:], isNewline, -1)
+		}
+	}
+
+	// Whitespace. A run containing newlines ends after its last newline;
+	// otherwise the final space is left to prefix the following word.
+	n := runLen(b, unicode.IsSpace, -1)
+	lastNL := -1
+	for i := 0; i < n; {
+		c, cw := utf8.DecodeRune(b[i:])
+		if isNewline(c) {
+			lastNL = i + cw
+		}
+		i += cw
+	}
+	if lastNL > 0 {
+		return lastNL
+	}
+	if n < len(b) && n > 1 {
+		_, lw := utf8.DecodeLastRune(b[:n])
+		return n - lw
+	}
+	return max(n, w)
+}
+
+func contraction(b []byte) int {
+	for _, c := range []string{"re", "ve", "ll", "s", "t", "m", "d"} {
+		if len(b) >= len(c) && equalFoldASCII(b[:len(c)], c) {
+			return len(c)
+		}
+	}
+	return 0
+}
+
+func equalFoldASCII(b []byte, s string) bool {
+	for i := range b {
+		c := b[i]
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// runLen returns the byte length of the longest prefix of b whose runes
+// satisfy pred, stopping after limit runes when limit >= 0.
+func runLen(b []byte, pred func(rune) bool, limit int) int {
+	n, count := 0, 0
+	for n < len(b) && (limit < 0 || count < limit) {
+		r, w := utf8.DecodeRune(b[n:])
+		if !pred(r) {
+			break
+		}
+		n += w
+		count++
+	}
+	return n
+}
+
+func isPunct(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+func isNewline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+// appendPieceTokens appends the end offsets of the tokens piece counts
+// (see ApproxTokenizer): one token for a known single token, otherwise one
+// per byte, all of a rune's tokens ending after it.
+func appendPieceTokens(out []int, piece []byte, base int) []int {
+	if singleToken(piece) {
+		return append(out, base+len(piece))
+	}
+	for off := 0; off < len(piece); {
+		_, w := utf8.DecodeRune(piece[off:])
+		off += w
+		for k := 0; k < w; k++ {
+			out = append(out, base+off)
+		}
+	}
+	return out
+}
+
+func singleToken(piece []byte) bool {
+	if len(piece) == 1 || singleTokens[string(piece)] {
+		return true
+	}
+	// cl100k has a token for every group of up to three ASCII digits.
+	for _, c := range piece {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(piece) <= 3
+}
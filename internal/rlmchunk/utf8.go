@@ -0,0 +1,80 @@
+package rlmchunk
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encodings accepted in Options.Encoding.
+const (
+	// EncodingUTF8 keeps chunk boundaries on rune starts.
+	EncodingUTF8 = "utf-8"
+	// EncodingRaw cuts at exact byte offsets.
+	EncodingRaw = "raw"
+)
+
+func normalizeEncoding(enc string) (string, error) {
+	switch strings.ToLower(enc) {
+	case "", "utf-8", "utf8":
+		return EncodingUTF8, nil
+	case "raw", "binary":
+		return EncodingRaw, nil
+	}
+	return "", fmt.Errorf("unsupported encoding %q (want utf-8 or raw)", enc)
+}
+
+// RuneStart returns the start of the UTF-8 sequence containing the byte at
+// off in a file of the given size. off is returned unchanged when it already
+// starts a rune, lies outside (0, size), or sits in invalid UTF-8.
+func RuneStart(r io.ReaderAt, off, size int64) (int64, error) {
+	if off <= 0 || off >= size {
+		return off, nil
+	}
+	lo := max(off-(utf8.UTFMax-1), 0)
+	var buf [2*utf8.UTFMax - 1]byte
+	b := buf[:min(off+utf8.UTFMax, size)-lo]
+	if _, err := r.ReadAt(b, lo); err != nil && err != io.EOF {
+		return off, err
+	}
+	i := int(off - lo)
+	if utf8.RuneStart(b[i]) {
+		return off, nil
+	}
+	for j := i - 1; j >= 0; j-- {
+		if !utf8.RuneStart(b[j]) {
+			continue
+		}
+		if c, n := utf8.DecodeRune(b[j:]); (c != utf8.RuneError || n > 1) && j+n > i {
+			return lo + int64(j), nil
+		}
+		break
+	}
+	return off, nil
+}
+
+// nextRuneStart returns the first rune start after off.
+func nextRuneStart(r io.ReaderAt, off, size int64) (int64, error) {
+	for next := off + 1; next < size; next++ {
+		s, err := RuneStart(r, next, size)
+		if err != nil || s == next {
+			return next, err
+		}
+	}
+	return size, nil
+}
+
+// runeCut returns the length of b without a trailing incomplete UTF-8
+// sequence.
+func runeCut(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return len(b)
+			}
+			return i
+		}
+	}
+	return len(b)
+}
@@ -1,9 +1,8 @@
 package rlmfiles
 
 import (
-	"io/fs"
-	"path/filepath"
-	"strings"
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmfilter"
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmwalk"
 )
 
 type FileInfo struct {
@@ -11,30 +10,10 @@ type FileInfo struct {
 	Size int64  `json:"size"`
 }
 
-func List(contextDir string) ([]FileInfo, error) {
+func List(contextDir string, filter rlmfilter.Filter) ([]FileInfo, error) {
 	var out []FileInfo
-	err := filepath.WalkDir(contextDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-			name := d.Name()
-			if strings.HasPrefix(name, ".") {
-				return filepath.SkipDir
-			}
-			if name == "node_modules" {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-		if strings.HasPrefix(d.Name(), ".") {
-			return nil
-		}
-		info, err := d.Info()
-		if err != nil {
-			return err
-		}
-		out = append(out, FileInfo{Path: path, Size: info.Size()})
+	err := rlmwalk.Walk(contextDir, filter, func(e rlmwalk.Entry) error {
+		out = append(out, FileInfo{Path: e.Path, Size: e.Info.Size()})
 		return nil
 	})
 	if err != nil {
@@ -0,0 +1,107 @@
+package rlmfilter
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filter selects files by glob and size. Paths are slash-separated and
+// relative to the context directory.
+//
+// Patterns use doublestar semantics: "*" and "?" never cross a "/", "**" as a
+// whole segment matches zero or more directories. A pattern without any "/"
+// is matched against the file's base name, so "*.txt" selects text files at
+// any depth.
+type Filter struct {
+	Include []string
+	Exclude []string
+	// MaxSize skips files larger than this many bytes; 0 means no limit.
+	MaxSize int64
+}
+
+func (f Filter) Validate() error {
+	for _, p := range append(append([]string(nil), f.Include...), f.Exclude...) {
+		if _, err := path.Match(strings.ReplaceAll(p, "**", "*"), ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", p, err)
+		}
+	}
+	if f.MaxSize < 0 {
+		return fmt.Errorf("max size must be >= 0")
+	}
+	return nil
+}
+
+// Match reports whether the file at rel with the given size is selected.
+func (f Filter) Match(rel string, size int64) bool {
+	if f.MaxSize > 0 && size > f.MaxSize {
+		return false
+	}
+	for _, p := range f.Exclude {
+		if matchPath(p, rel) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, p := range f.Include {
+		if matchPath(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipDir reports whether every file under the directory rel is excluded, so
+// walkers can avoid descending into it.
+func (f Filter) SkipDir(rel string) bool {
+	for _, p := range f.Exclude {
+		if dir, ok := strings.CutSuffix(p, "/**"); ok && Match(strings.TrimPrefix(dir, "/"), rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPath(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		return Match(pattern, path.Base(rel))
+	}
+	return Match(strings.TrimPrefix(pattern, "/"), rel)
+}
+
+// Match reports whether the slash-separated name matches the doublestar
+// pattern.
+func Match(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			// Collapse consecutive "**" and try every split point.
+			for len(pat) > 0 && pat[0] == "**" {
+				pat = pat[1:]
+			}
+			if len(pat) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		ok, err := path.Match(strings.ReplaceAll(pat[0], "**", "*"), name[0])
+		if err != nil || !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
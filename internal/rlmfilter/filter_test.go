@@ -0,0 +1,42 @@
+package rlmfilter
+
+import "testing"
+
+func TestMatch_Doublestar(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"**/archive/**", "archive/a.txt", true},
+		{"**/archive/**", "x/y/archive/z/a.txt", true},
+		{"**/archive/**", "archived/a.txt", false},
+		{"filings/*.txt", "filings/a.txt", true},
+		{"filings/*.txt", "filings/sub/a.txt", false},
+		{"filings/**/*.txt", "filings/sub/a.txt", true},
+		{"filings/**/*.txt", "filings/a.txt", true},
+	}
+	for _, c := range cases {
+		if got := Match(c.pattern, c.name); got != c.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	f := Filter{Include: []string{"*.txt", "*.csv"}, Exclude: []string{"**/archive/**"}, MaxSize: 100}
+	if !f.Match("deep/dir/a.txt", 10) {
+		t.Fatal("basename pattern should match at any depth")
+	}
+	if f.Match("a.json", 10) {
+		t.Fatal("non-included extension matched")
+	}
+	if f.Match("old/archive/a.txt", 10) {
+		t.Fatal("excluded path matched")
+	}
+	if f.Match("big.csv", 101) {
+		t.Fatal("oversized file matched")
+	}
+	if !f.SkipDir("old/archive") || f.SkipDir("old") {
+		t.Fatal("unexpected SkipDir result")
+	}
+}
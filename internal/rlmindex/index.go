@@ -0,0 +1,516 @@
+package rlmindex
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmfilter"
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmwalk"
+)
+
+const (
+	DefaultBlockSize = 256 * 1024
+
+	metaName     = "files.json"
+	postingsName = "trigrams.bin"
+	formatMagic  = "RLMTRI2\n"
+	headerLen    = len(formatMagic) + 8 + 4 + 8 // build ID, count, table offset
+	entrySize    = 16                           // trigram uint32 + offset uint64 + length uint32
+
+	indexVersion = 2
+)
+
+type Options struct {
+	ContextDir string
+	IndexDir   string
+	BlockSize  int
+}
+
+// Block is a line-aligned byte range of a file. Blocks never split a line, so
+// every trigram of a single-line match lives in exactly one block.
+type Block struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Line  int   `json:"line"`
+}
+
+type FileEntry struct {
+	Path    string  `json:"path"`
+	Size    int64   `json:"size"`
+	ModTime int64   `json:"mtime_ns"`
+	Binary  bool    `json:"binary,omitempty"`
+	Blocks  []Block `json:"blocks,omitempty"`
+}
+
+type meta struct {
+	Version int `json:"version"`
+	// BuildID is also written to the posting file, so that files of
+	// different builds are never used together.
+	BuildID    uint64      `json:"build_id"`
+	ContextDir string      `json:"context_dir"`
+	BlockSize  int         `json:"block_size"`
+	BuiltAt    time.Time   `json:"built_at"`
+	Files      []FileEntry `json:"files"`
+}
+
+type Stats struct {
+	IndexDir   string `json:"index_dir"`
+	ContextDir string `json:"context_dir"`
+	Files      int    `json:"files"`
+	Bytes      int64  `json:"bytes"`
+	Blocks     int    `json:"blocks"`
+	Trigrams   int    `json:"trigrams"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type Index struct {
+	ContextDir string
+	BlockSize  int
+	Files      []FileEntry
+
+	byPath map[string]int
+	post   *os.File
+	count  int
+	table  int64 // offset of the trigram table
+}
+
+// Build walks the context directory and writes a trigram posting-list index to
+// IndexDir, replacing any previous index.
+func Build(opts Options) (Stats, error) {
+	if opts.ContextDir == "" {
+		return Stats{}, fmt.Errorf("context_dir is required")
+	}
+	if opts.IndexDir == "" {
+		return Stats{}, fmt.Errorf("index_dir is required")
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = DefaultBlockSize
+	}
+	contextDir, err := filepath.Abs(opts.ContextDir)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return Stats{}, err
+	}
+	if err := os.MkdirAll(opts.IndexDir, 0o755); err != nil {
+		return Stats{}, err
+	}
+
+	start := time.Now()
+	m := meta{Version: indexVersion, BuildID: binary.LittleEndian.Uint64(id[:]), ContextDir: contextDir, BlockSize: opts.BlockSize}
+	postings := newPostingWriter(opts.IndexDir)
+	defer postings.cleanup()
+	st := Stats{IndexDir: opts.IndexDir, ContextDir: contextDir}
+
+	err = rlmwalk.Walk(contextDir, rlmfilter.Filter{}, func(e rlmwalk.Entry) error {
+		entry, err := indexFile(e.Path, uint32(len(m.Files)), opts.BlockSize, postings)
+		if err != nil {
+			return err
+		}
+		entry.Path = e.Rel
+		m.Files = append(m.Files, entry)
+		st.Files++
+		st.Bytes += entry.Size
+		st.Blocks += len(entry.Blocks)
+		return nil
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+
+	// The posting file goes into place first; until files.json follows,
+	// Open rejects the pair as coming from different builds.
+	postingsPath := filepath.Join(opts.IndexDir, postingsName)
+	defer os.Remove(postingsPath + ".tmp")
+	if st.Trigrams, err = postings.finish(postingsPath, m.BuildID); err != nil {
+		return Stats{}, err
+	}
+	if err := os.Rename(postingsPath+".tmp", postingsPath); err != nil {
+		return Stats{}, err
+	}
+	m.BuiltAt = time.Now().UTC()
+	b, err := json.Marshal(m)
+	if err != nil {
+		return Stats{}, err
+	}
+	if err := writeFileAtomic(filepath.Join(opts.IndexDir, metaName), b); err != nil {
+		return Stats{}, err
+	}
+
+	st.DurationMs = time.Since(start).Milliseconds()
+	return st, nil
+}
+
+func indexFile(path string, fileID uint32, blockSize int, postings *postingWriter) (FileEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return FileEntry{}, err
+	}
+	entry := FileEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+
+	if isLikelyBinary(f) {
+		entry.Binary = true
+		return entry, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return FileEntry{}, err
+	}
+
+	reader := bufio.NewReaderSize(f, 256*1024)
+	seen := make(map[uint32]struct{})
+	var pos int64
+	lineNo := 1
+	cur := Block{Start: 0, Line: 1}
+	// prev holds the last two bytes of the current line, so trigrams that
+	// straddle a fragment boundary of a very long line are still recorded.
+	var prev []byte
+
+	flush := func() error {
+		cur.End = pos
+		key := uint64(fileID)<<32 | uint64(len(entry.Blocks))
+		if err := postings.add(seen, key); err != nil {
+			return err
+		}
+		clear(seen)
+		entry.Blocks = append(entry.Blocks, cur)
+		cur = Block{Start: pos, Line: lineNo}
+		return nil
+	}
+
+	for {
+		frag, err := reader.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			return FileEntry{}, err
+		}
+		if len(frag) == 0 && err == io.EOF {
+			break
+		}
+
+		if len(prev) > 0 {
+			edge := append(prev, frag[:min(2, len(frag))]...)
+			addTrigrams(seen, edge)
+		}
+		addTrigrams(seen, frag)
+		pos += int64(len(frag))
+
+		if err == nil {
+			prev = prev[:0]
+			lineNo++
+			if pos-cur.Start >= int64(blockSize) {
+				if err := flush(); err != nil {
+					return FileEntry{}, err
+				}
+			}
+			continue
+		}
+		prev = append(prev, frag[max(0, len(frag)-2):]...)
+		if len(prev) > 2 {
+			prev = append(prev[:0], prev[len(prev)-2:]...)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if pos > cur.Start || len(entry.Blocks) == 0 {
+		if err := flush(); err != nil {
+			return FileEntry{}, err
+		}
+	}
+	return entry, nil
+}
+
+// Posting lists are sorted (file, block) pairs, delta-encoded as uvarints: the
+// file delta, then the block number (delta from the previous block when the
+// file did not change).
+func appendPostingList(dst []byte, keys []uint64) []byte {
+	var lastFile, lastBlock uint64
+	for i, k := range keys {
+		file, block := k>>32, k&0xffffffff
+		if i == 0 || file != lastFile {
+			dst = binary.AppendUvarint(dst, file-lastFile)
+			dst = binary.AppendUvarint(dst, block)
+		} else {
+			dst = binary.AppendUvarint(dst, 0)
+			dst = binary.AppendUvarint(dst, block-lastBlock)
+		}
+		lastFile, lastBlock = file, block
+	}
+	return dst
+}
+
+func decodePostingList(b []byte) ([]uint64, error) {
+	var out []uint64
+	var file, block uint64
+	first := true
+	for len(b) > 0 {
+		df, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("corrupt posting list")
+		}
+		b = b[n:]
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("corrupt posting list")
+		}
+		b = b[n:]
+		if first || df != 0 {
+			file += df
+			block = v
+		} else {
+			block += v
+		}
+		first = false
+		out = append(out, file<<32|block)
+	}
+	return out, nil
+}
+
+// Open loads the index in indexDir. The posting file stays open for lookups
+// until Close is called. It fails when files.json and the posting file come
+// from different builds, as after a build that was interrupted.
+func Open(indexDir string) (*Index, error) {
+	b, err := os.ReadFile(filepath.Join(indexDir, metaName))
+	if err != nil {
+		return nil, err
+	}
+	var m meta
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	if m.Version != indexVersion {
+		return nil, fmt.Errorf("unsupported index version %d", m.Version)
+	}
+
+	f, err := os.Open(filepath.Join(indexDir, postingsName))
+	if err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, headerLen)
+	if _, err := io.ReadFull(f, hdr); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if string(hdr[:len(formatMagic)]) != formatMagic {
+		_ = f.Close()
+		return nil, fmt.Errorf("not an rlm trigram index: %s", f.Name())
+	}
+	h := hdr[len(formatMagic):]
+	if binary.LittleEndian.Uint64(h[0:]) != m.BuildID {
+		_ = f.Close()
+		return nil, fmt.Errorf("index files in %s are from different builds; rebuild the index", indexDir)
+	}
+
+	ix := &Index{
+		ContextDir: m.ContextDir,
+		BlockSize:  m.BlockSize,
+		Files:      m.Files,
+		byPath:     make(map[string]int, len(m.Files)),
+		post:       f,
+		count:      int(binary.LittleEndian.Uint32(h[8:])),
+		table:      int64(binary.LittleEndian.Uint64(h[12:])),
+	}
+	for i, fe := range m.Files {
+		ix.byPath[fe.Path] = i
+	}
+	return ix, nil
+}
+
+func (ix *Index) Close() error {
+	return ix.post.Close()
+}
+
+// Lookup returns the file ID and entry for a slash-separated path relative to
+// the indexed context directory.
+func (ix *Index) Lookup(rel string) (int, FileEntry, bool) {
+	id, ok := ix.byPath[rel]
+	if !ok {
+		return 0, FileEntry{}, false
+	}
+	return id, ix.Files[id], true
+}
+
+// Fresh reports whether the entry still describes the file on disk.
+func (fe FileEntry) Fresh(info fs.FileInfo) bool {
+	return fe.Size == info.Size() && fe.ModTime == info.ModTime().UnixNano()
+}
+
+// Candidates returns, per file ID, the sorted block numbers containing every
+// trigram of lit. ok is false when lit yields no usable trigrams, in which case
+// the index cannot narrow the search.
+func (ix *Index) Candidates(lit string, ignoreCase bool) (map[int][]int, bool, error) {
+	tris := QueryTrigrams(lit, ignoreCase)
+	if len(tris) == 0 {
+		return nil, false, nil
+	}
+
+	lists := make([][]uint64, 0, len(tris))
+	for _, tri := range tris {
+		l, err := ix.postings(tri)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(l) == 0 {
+			return map[int][]int{}, true, nil
+		}
+		lists = append(lists, l)
+	}
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+
+	cur := lists[0]
+	for _, l := range lists[1:] {
+		cur = intersect(cur, l)
+		if len(cur) == 0 {
+			break
+		}
+	}
+
+	out := make(map[int][]int)
+	for _, k := range cur {
+		id := int(k >> 32)
+		out[id] = append(out[id], int(k&0xffffffff))
+	}
+	return out, true, nil
+}
+
+func (ix *Index) postings(tri uint32) ([]uint64, error) {
+	var entry [entrySize]byte
+	base := ix.table
+	var readErr error
+	i := sort.Search(ix.count, func(i int) bool {
+		if readErr != nil {
+			return true
+		}
+		if _, err := ix.post.ReadAt(entry[:], base+int64(i)*entrySize); err != nil {
+			readErr = err
+			return true
+		}
+		return binary.LittleEndian.Uint32(entry[:4]) >= tri
+	})
+	if readErr != nil {
+		return nil, readErr
+	}
+	if i >= ix.count {
+		return nil, nil
+	}
+	if _, err := ix.post.ReadAt(entry[:], base+int64(i)*entrySize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(entry[:4]) != tri {
+		return nil, nil
+	}
+	off := binary.LittleEndian.Uint64(entry[4:12])
+	n := binary.LittleEndian.Uint32(entry[12:16])
+	buf := make([]byte, n)
+	if _, err := ix.post.ReadAt(buf, int64(headerLen)+int64(off)); err != nil {
+		return nil, err
+	}
+	return decodePostingList(buf)
+}
+
+// QueryTrigrams returns the distinct trigrams a match of lit must contain.
+// The index folds ASCII letters only, so for case-insensitive queries any
+// trigram with a non-ASCII byte is dropped, as are those with 'i', 'k' or 's':
+// Unicode case mapping/folding relates those to non-ASCII runes (U+0130,
+// U+212A KELVIN SIGN, U+017F LONG S).
+func QueryTrigrams(lit string, ignoreCase bool) []uint32 {
+	seen := make(map[uint32]bool)
+	var out []uint32
+	for i := 0; i+3 <= len(lit); i++ {
+		a, b, c := lit[i], lit[i+1], lit[i+2]
+		if ignoreCase && (!foldSafe(a) || !foldSafe(b) || !foldSafe(c)) {
+			continue
+		}
+		tri := packTrigram(lower(a), lower(b), lower(c))
+		if !seen[tri] {
+			seen[tri] = true
+			out = append(out, tri)
+		}
+	}
+	return out
+}
+
+func foldSafe(c byte) bool {
+	if c >= 0x80 {
+		return false
+	}
+	switch lower(c) {
+	case 'i', 'k', 's':
+		return false
+	}
+	return true
+}
+
+func intersect(a, b []uint64) []uint64 {
+	out := a[:0:0]
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+func addTrigrams(seen map[uint32]struct{}, b []byte) {
+	for i := 0; i+3 <= len(b); i++ {
+		seen[packTrigram(lower(b[i]), lower(b[i+1]), lower(b[i+2]))] = struct{}{}
+	}
+}
+
+func packTrigram(a, b, c byte) uint32 {
+	return uint32(a)<<16 | uint32(b)<<8 | uint32(c)
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
+func isLikelyBinary(f *os.File) bool {
+	buf := make([]byte, 4096)
+	n, err := f.Read(buf)
+	if err != nil && err != io.EOF {
+		return false
+	}
+	for _, b := range buf[:n] {
+		if b == 0x00 {
+			return true
+		}
+	}
+	return false
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
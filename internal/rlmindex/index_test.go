@@ -0,0 +1,179 @@
+package rlmindex
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuild_CandidatesNarrowToBlocks(t *testing.T) {
+	dir := t.TempDir()
+	var b strings.Builder
+	for i := 0; i < 100; i++ {
+		b.WriteString("filler line of text\n")
+	}
+	b.WriteString("the NEEDLE is here\n")
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("nothing to see\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	idxDir := filepath.Join(t.TempDir(), "index")
+	if _, err := Build(Options{ContextDir: dir, IndexDir: idxDir, BlockSize: 256}); err != nil {
+		t.Fatal(err)
+	}
+	ix, err := Open(idxDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	id, entry, ok := ix.Lookup("a.txt")
+	if !ok {
+		t.Fatal("a.txt missing from index")
+	}
+	cands, ok, err := ix.Candidates("needle", true)
+	if err != nil || !ok {
+		t.Fatalf("candidates: ok=%v err=%v", ok, err)
+	}
+	if len(cands) != 1 || len(cands[id]) != 1 {
+		t.Fatalf("expected exactly one candidate block, got %v", cands)
+	}
+	blk := entry.Blocks[cands[id][0]]
+	if blk.Line > 101 || blk.End != entry.Size {
+		t.Fatalf("unexpected candidate block %+v", blk)
+	}
+
+	if _, ok, _ := ix.Candidates("ab", false); ok {
+		t.Fatal("queries shorter than a trigram must not narrow")
+	}
+}
+
+func TestBuild_SpilledRunsMatchInMemory(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 20; i++ {
+		var b strings.Builder
+		for j := 0; j < 50; j++ {
+			fmt.Fprintf(&b, "file %d line %d says %x\n", i, j, i*j*7919)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%02d.txt", i)), []byte(b.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build := func(name string) []byte {
+		t.Helper()
+		idxDir := filepath.Join(t.TempDir(), name)
+		if _, err := Build(Options{ContextDir: dir, IndexDir: idxDir, BlockSize: 256}); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(filepath.Join(idxDir, postingsName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if left, _ := filepath.Glob(filepath.Join(idxDir, "*.tmp")); len(left) > 0 {
+			t.Errorf("temporary files left behind: %q", left)
+		}
+		runs, _ := filepath.Glob(filepath.Join(idxDir, "*.run"))
+		if len(runs) > 0 {
+			t.Errorf("run files left behind: %q", runs)
+		}
+		return b
+	}
+
+	whole := build("whole")
+	defer func(n int) { spillKeys = n }(spillKeys)
+	spillKeys = 100
+	spilled := build("spilled")
+	// Only the build IDs differ.
+	if string(whole[headerLen:]) != string(spilled[headerLen:]) || string(whole[16:headerLen]) != string(spilled[16:headerLen]) {
+		t.Error("spilled build differs from the in-memory one")
+	}
+}
+
+func TestOpen_RejectsMixedBuilds(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("some text\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	a, b := filepath.Join(t.TempDir(), "a"), filepath.Join(t.TempDir(), "b")
+	for _, idxDir := range []string{a, b} {
+		if _, err := Build(Options{ContextDir: dir, IndexDir: idxDir}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	post, err := os.ReadFile(filepath.Join(b, postingsName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(a, postingsName), post, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if ix, err := Open(a); err == nil {
+		ix.Close()
+		t.Fatal("opened an index whose files come from different builds")
+	}
+}
+
+func TestLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	text := "one\ntwo\r\n\nfour"
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	linesDir := filepath.Join(dir, "lines")
+
+	if _, err := OpenLines(linesDir, path); !os.IsNotExist(err) {
+		t.Fatalf("OpenLines without an index: %v", err)
+	}
+	st, err := BuildLines(linesDir, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Lines != 4 || st.Bytes != int64(len(text)) {
+		t.Fatalf("stats = %+v", st)
+	}
+	l, err := OpenLines(linesDir, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for n, want := range []int64{0, 4, 9, 10, 14} {
+		got, err := l.LineStart(n + 1)
+		if err != nil || got != want {
+			t.Errorf("LineStart(%d) = %d, %v; want %d", n+1, got, err, want)
+		}
+	}
+	for off, want := range map[int64]int{0: 1, 3: 1, 4: 2, 8: 2, 9: 3, 10: 4, 13: 4} {
+		if got, err := l.LineOf(off); err != nil || got != want {
+			t.Errorf("LineOf(%d) = %d, %v; want %d", off, got, err, want)
+		}
+	}
+	if _, err := l.LineStart(6); err == nil {
+		t.Error("LineStart past the end should fail")
+	}
+
+	// A changed file makes the index stale until it is rebuilt.
+	if err := os.WriteFile(path, []byte(text+"\nfive\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenLines(linesDir, path); err != ErrStaleLines {
+		t.Fatalf("OpenLines after a change: %v", err)
+	}
+	l2, built, err := EnsureLines(linesDir, path)
+	if err != nil || !built {
+		t.Fatalf("EnsureLines: built=%v err=%v", built, err)
+	}
+	defer l2.Close()
+	if l2.Count() != 5 {
+		t.Errorf("Count = %d, want 5", l2.Count())
+	}
+	if end, _ := l2.LineStart(6); end != int64(len(text)+6) {
+		t.Errorf("end = %d", end)
+	}
+}
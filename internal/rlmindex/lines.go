@@ -0,0 +1,263 @@
+package rlmindex
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// A line index is a sidecar file holding the offset of every line start of
+// one file, so that line numbers and byte offsets translate without reading
+// the file. Offsets are fixed-width (4 bytes, or 8 for files of 4GiB and
+// more) and looked up in place, so a lookup costs one small read however
+// large the file is.
+const (
+	linesMagic     = "RLMLIN1\n"
+	linesHeaderLen = len(linesMagic) + 8 + 8 + 4 + 8 // size, mtime, width, count
+)
+
+// ErrStaleLines is returned by OpenLines when the file changed since its line
+// index was built.
+var ErrStaleLines = errors.New("line index is out of date")
+
+// Lines is an open line index.
+type Lines struct {
+	f     *os.File
+	size  int64
+	width int
+	count int64 // newlines in the file
+	last  int64 // offset after the last newline
+}
+
+// LinesStats describes a built line index.
+type LinesStats struct {
+	Path    string `json:"path"`
+	Sidecar string `json:"sidecar"`
+	Bytes   int64  `json:"bytes"`
+	Lines   int    `json:"lines"`
+}
+
+// LinesPath returns where the line index of path is kept in dir.
+func LinesPath(dir, path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16])+".lines")
+}
+
+// BuildLines writes the line index of path to dir, replacing any previous
+// one.
+func BuildLines(dir, path string) (LinesStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return LinesStats{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return LinesStats{}, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return LinesStats{}, err
+	}
+
+	sidecar := LinesPath(dir, path)
+	tmp := sidecar + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return LinesStats{}, err
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	width := 4
+	if info.Size() > 1<<32-1 {
+		width = 8
+	}
+	w := bufio.NewWriterSize(out, 64*1024)
+	if _, err := w.Write(make([]byte, linesHeaderLen)); err != nil {
+		return LinesStats{}, err
+	}
+
+	buf := make([]byte, 256*1024)
+	var pos, count, last int64
+	var entry [8]byte
+	for {
+		n, err := f.Read(buf)
+		for i := 0; i < n; {
+			j := bytes.IndexByte(buf[i:n], '\n')
+			if j < 0 {
+				break
+			}
+			i += j + 1
+			last = pos + int64(i)
+			if width == 4 {
+				binary.LittleEndian.PutUint32(entry[:], uint32(last))
+			} else {
+				binary.LittleEndian.PutUint64(entry[:], uint64(last))
+			}
+			if _, err := w.Write(entry[:width]); err != nil {
+				return LinesStats{}, err
+			}
+			count++
+		}
+		pos += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return LinesStats{}, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return LinesStats{}, err
+	}
+
+	hdr := make([]byte, 0, linesHeaderLen)
+	hdr = append(hdr, linesMagic...)
+	hdr = binary.LittleEndian.AppendUint64(hdr, uint64(pos))
+	hdr = binary.LittleEndian.AppendUint64(hdr, uint64(info.ModTime().UnixNano()))
+	hdr = binary.LittleEndian.AppendUint32(hdr, uint32(width))
+	hdr = binary.LittleEndian.AppendUint64(hdr, uint64(count))
+	if _, err := out.WriteAt(hdr, 0); err != nil {
+		return LinesStats{}, err
+	}
+	if err := out.Close(); err != nil {
+		return LinesStats{}, err
+	}
+	if err := os.Rename(tmp, sidecar); err != nil {
+		return LinesStats{}, err
+	}
+
+	lines := count
+	if pos > last {
+		lines++
+	}
+	return LinesStats{Path: path, Sidecar: sidecar, Bytes: pos, Lines: int(lines)}, nil
+}
+
+// OpenLines opens the line index of path in dir. It fails with
+// ErrStaleLines when the file changed since the index was built, and with
+// an error satisfying os.IsNotExist when there is none.
+func OpenLines(dir, path string) (*Lines, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(LinesPath(dir, path))
+	if err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, linesHeaderLen)
+	if _, err := io.ReadFull(f, hdr); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if string(hdr[:len(linesMagic)]) != linesMagic {
+		_ = f.Close()
+		return nil, fmt.Errorf("not an rlm line index: %s", f.Name())
+	}
+	h := hdr[len(linesMagic):]
+	l := &Lines{
+		f:     f,
+		size:  int64(binary.LittleEndian.Uint64(h[0:])),
+		width: int(binary.LittleEndian.Uint32(h[16:])),
+		count: int64(binary.LittleEndian.Uint64(h[20:])),
+	}
+	mtime := int64(binary.LittleEndian.Uint64(h[8:]))
+	if l.size != info.Size() || mtime != info.ModTime().UnixNano() {
+		_ = f.Close()
+		return nil, ErrStaleLines
+	}
+	if l.width != 4 && l.width != 8 {
+		_ = f.Close()
+		return nil, fmt.Errorf("corrupt line index: %s", f.Name())
+	}
+	if l.count > 0 {
+		if l.last, err = l.entry(l.count - 1); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// EnsureLines opens the line index of path in dir, building it first when
+// it is missing, out of date or unreadable. built reports whether it was.
+func EnsureLines(dir, path string) (l *Lines, built bool, err error) {
+	if l, err := OpenLines(dir, path); err == nil {
+		return l, false, nil
+	}
+	if _, err := BuildLines(dir, path); err != nil {
+		return nil, false, err
+	}
+	l, err = OpenLines(dir, path)
+	return l, true, err
+}
+
+func (l *Lines) Close() error {
+	return l.f.Close()
+}
+
+// Size returns the size of the indexed file.
+func (l *Lines) Size() int64 {
+	return l.size
+}
+
+// Count returns the number of lines; a final line without a newline counts.
+func (l *Lines) Count() int {
+	if l.size > l.last {
+		return int(l.count) + 1
+	}
+	return int(l.count)
+}
+
+// LineStart returns the offset of the first byte of line n (1-based). n may
+// be Count()+1, which returns the file size.
+func (l *Lines) LineStart(n int) (int64, error) {
+	switch {
+	case n < 1 || n > l.Count()+1:
+		return 0, fmt.Errorf("line %d out of range (%d lines)", n, l.Count())
+	case n == 1:
+		return 0, nil
+	case int64(n-2) < l.count:
+		return l.entry(int64(n - 2))
+	}
+	return l.size, nil
+}
+
+// LineOf returns the 1-based line that offset off falls in. The file size
+// maps to Count()+1 after a final newline.
+func (l *Lines) LineOf(off int64) (int, error) {
+	var readErr error
+	// Newlines ending at or before off, i.e. lines that end before it.
+	i := sort.Search(int(l.count), func(i int) bool {
+		e, err := l.entry(int64(i))
+		if err != nil {
+			readErr = err
+			return true
+		}
+		return e > off
+	})
+	return i + 1, readErr
+}
+
+func (l *Lines) entry(i int64) (int64, error) {
+	var b [8]byte
+	if _, err := l.f.ReadAt(b[:l.width], int64(linesHeaderLen)+i*int64(l.width)); err != nil {
+		return 0, err
+	}
+	if l.width == 4 {
+		return int64(binary.LittleEndian.Uint32(b[:4])), nil
+	}
+	return int64(binary.LittleEndian.Uint64(b[:])), nil
+}
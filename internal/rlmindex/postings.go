@@ -0,0 +1,245 @@
+package rlmindex
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+)
+
+// spillKeys is how many postings a build keeps in memory before writing them
+// out as a sorted run.
+var spillKeys = 1 << 22
+
+// postingWriter collects the postings of a build with bounded memory. Once
+// spillKeys postings are held they are written, sorted by trigram, to a
+// temporary run file; finish merges the runs into the posting file. Files
+// are indexed in ID order, so the keys of a trigram in one run all follow
+// those in earlier runs, and merging a trigram's lists is concatenating them
+// run by run.
+type postingWriter struct {
+	dir  string // where temporary files go
+	mem  map[uint32][]uint64
+	keys int
+	runs []string
+}
+
+func newPostingWriter(dir string) *postingWriter {
+	return &postingWriter{dir: dir, mem: make(map[uint32][]uint64)}
+}
+
+// add records key, a (file, block) pair, for every trigram of tris.
+func (w *postingWriter) add(tris map[uint32]struct{}, key uint64) error {
+	for tri := range tris {
+		w.mem[tri] = append(w.mem[tri], key)
+	}
+	w.keys += len(tris)
+	if w.keys >= spillKeys {
+		return w.spill()
+	}
+	return nil
+}
+
+// spill writes the postings held in memory to a new run. A run is a sequence
+// of records sorted by trigram: the trigram, the byte length of its posting
+// list as a uvarint, then the list encoded by appendPostingList.
+func (w *postingWriter) spill() error {
+	if len(w.mem) == 0 {
+		return nil
+	}
+	tris := make([]uint32, 0, len(w.mem))
+	for tri := range w.mem {
+		tris = append(tris, tri)
+	}
+	sort.Slice(tris, func(i, j int) bool { return tris[i] < tris[j] })
+
+	f, err := os.CreateTemp(w.dir, "postings-*.run")
+	if err != nil {
+		return err
+	}
+	w.runs = append(w.runs, f.Name())
+	bw := bufio.NewWriterSize(f, 256*1024)
+	var rec, list []byte
+	for _, tri := range tris {
+		list = appendPostingList(list[:0], w.mem[tri])
+		rec = binary.LittleEndian.AppendUint32(rec[:0], tri)
+		rec = binary.AppendUvarint(rec, uint64(len(list)))
+		if _, err := bw.Write(rec); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if _, err := bw.Write(list); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	clear(w.mem)
+	w.keys = 0
+	return nil
+}
+
+// finish merges every posting into the posting file at path and returns the
+// number of distinct trigrams. The file is written to path+".tmp"; renaming
+// it into place is left to the caller.
+//
+// The file holds a header (magic, build ID, trigram count, table offset),
+// the posting lists, then the table: one entrySize entry per trigram, sorted,
+// giving the offset of its list from the end of the header and its length.
+// The table goes last so that lists and entries can be written as they are
+// merged; entries are staged in a temporary file meanwhile.
+func (w *postingWriter) finish(path string, buildID uint64) (int, error) {
+	if err := w.spill(); err != nil {
+		return 0, err
+	}
+	runs := make([]*runReader, 0, len(w.runs))
+	defer func() {
+		for _, r := range runs {
+			_ = r.f.Close()
+		}
+	}()
+	for _, name := range w.runs {
+		f, err := os.Open(name)
+		if err != nil {
+			return 0, err
+		}
+		r := &runReader{f: f, r: bufio.NewReaderSize(f, 64*1024)}
+		runs = append(runs, r)
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	out, err := os.Create(path + ".tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	table, err := os.CreateTemp(w.dir, "table-*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(table.Name())
+	defer table.Close()
+
+	data := bufio.NewWriterSize(out, 256*1024)
+	if _, err := data.Write(make([]byte, headerLen)); err != nil {
+		return 0, err
+	}
+	tw := bufio.NewWriterSize(table, 64*1024)
+
+	var (
+		count int
+		off   int64
+		keys  []uint64
+		list  []byte
+		entry [entrySize]byte
+	)
+	for {
+		// Runs are few, so the next trigram is found by scanning them all.
+		tri, found := uint32(0), false
+		for _, r := range runs {
+			if !r.done && (!found || r.tri < tri) {
+				tri, found = r.tri, true
+			}
+		}
+		if !found {
+			break
+		}
+		keys = keys[:0]
+		for _, r := range runs {
+			if r.done || r.tri != tri {
+				continue
+			}
+			k, err := decodePostingList(r.list)
+			if err != nil {
+				return 0, err
+			}
+			keys = append(keys, k...)
+			if err := r.next(); err != nil {
+				return 0, err
+			}
+		}
+
+		list = appendPostingList(list[:0], keys)
+		if _, err := data.Write(list); err != nil {
+			return 0, err
+		}
+		binary.LittleEndian.PutUint32(entry[0:], tri)
+		binary.LittleEndian.PutUint64(entry[4:], uint64(off))
+		binary.LittleEndian.PutUint32(entry[12:], uint32(len(list)))
+		if _, err := tw.Write(entry[:]); err != nil {
+			return 0, err
+		}
+		off += int64(len(list))
+		count++
+	}
+
+	if err := data.Flush(); err != nil {
+		return 0, err
+	}
+	if err := tw.Flush(); err != nil {
+		return 0, err
+	}
+	if _, err := table.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(out, table); err != nil {
+		return 0, err
+	}
+
+	hdr := make([]byte, 0, headerLen)
+	hdr = append(hdr, formatMagic...)
+	hdr = binary.LittleEndian.AppendUint64(hdr, buildID)
+	hdr = binary.LittleEndian.AppendUint32(hdr, uint32(count))
+	hdr = binary.LittleEndian.AppendUint64(hdr, uint64(headerLen)+uint64(off))
+	if _, err := out.WriteAt(hdr, 0); err != nil {
+		return 0, err
+	}
+	return count, out.Close()
+}
+
+// cleanup removes the run files.
+func (w *postingWriter) cleanup() {
+	for _, name := range w.runs {
+		_ = os.Remove(name)
+	}
+}
+
+// runReader reads the records of a run in order.
+type runReader struct {
+	f    *os.File
+	r    *bufio.Reader
+	tri  uint32
+	list []byte
+	done bool
+}
+
+func (r *runReader) next() error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		if err == io.EOF {
+			r.done = true
+			return nil
+		}
+		return err
+	}
+	r.tri = binary.LittleEndian.Uint32(hdr[:])
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return err
+	}
+	if uint64(cap(r.list)) < n {
+		r.list = make([]byte, n)
+	}
+	r.list = r.list[:n]
+	_, err = io.ReadFull(r.r, r.list)
+	return err
+}
@@ -0,0 +1,117 @@
+package rlmpeek
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+type AnchorOptions struct {
+	// From locates the start of the range: the start of its Occurrence-th
+	// match (1-based).
+	From       *regexp.Regexp
+	Occurrence int
+	// To, when set, ends the range at the start of its first match after
+	// the From match. Without it the range is DefaultBytes long.
+	To *regexp.Regexp
+	// MaxBytes, when > 0, caps the length of the range; scanning for To
+	// stops there.
+	MaxBytes int64
+}
+
+// Anchored is a range found by FindAnchors.
+type Anchored struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	// FromEnd is the end of the From match.
+	FromEnd int64 `json:"from_end"`
+	// ToFound reports whether To matched; when it did not, the range runs
+	// to EOF or MaxBytes.
+	ToFound bool `json:"to_found"`
+	// Truncated is set when MaxBytes cut the range short.
+	Truncated bool `json:"truncated"`
+}
+
+// FindAnchors streams r for the range delimited by opts.From and opts.To.
+// Like search, matches are looked for within lines, without their line
+// terminator, and lines longer than maxFragmentBytes are matched in
+// fragments.
+func FindAnchors(r *io.SectionReader, opts AnchorOptions) (Anchored, error) {
+	if opts.From == nil {
+		return Anchored{}, fmt.Errorf("a from regex is required")
+	}
+	if opts.Occurrence <= 0 {
+		opts.Occurrence = 1
+	}
+	size := r.Size()
+	reader := bufio.NewReaderSize(r, maxFragmentBytes)
+
+	var (
+		a     Anchored
+		pos   int64
+		seen  int
+		found bool
+	)
+	for {
+		frag, err := reader.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			return Anchored{}, err
+		}
+		fragStart := pos
+		pos += int64(len(frag))
+		// Match without the line terminator so that $ anchors at line end;
+		// offsets are unchanged since only the tail is dropped.
+		line := trimEOL(frag)
+
+		from := 0 // where To may match in frag
+		if !found {
+			for _, loc := range opts.From.FindAllIndex(line, -1) {
+				if seen++; seen == opts.Occurrence {
+					a.Start, a.FromEnd = fragStart+int64(loc[0]), fragStart+int64(loc[1])
+					from, found = loc[1], true
+					break
+				}
+			}
+		}
+		if found {
+			limit, capped := size, false
+			if opts.To == nil {
+				limit = a.Start + DefaultBytes
+			}
+			if opts.MaxBytes > 0 && a.Start+opts.MaxBytes < limit {
+				limit, capped = a.Start+opts.MaxBytes, true
+			}
+			if limit >= size {
+				limit, capped = size, false
+			}
+			if opts.To != nil {
+				for _, loc := range opts.To.FindAllIndex(line, -1) {
+					if loc[0] < from || loc[1] == loc[0] {
+						continue
+					}
+					if end := fragStart + int64(loc[0]); end <= limit {
+						a.End, a.ToFound = end, true
+						return a, nil
+					}
+					break
+				}
+			}
+			if opts.To == nil || pos >= limit {
+				a.End, a.Truncated = limit, capped
+				return a, nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return Anchored{}, fmt.Errorf("from regex has %d matches, occurrence %d requested", seen, opts.Occurrence)
+}
+
+// trimEOL returns b without a trailing "\n" or "\r\n".
+func trimEOL(b []byte) []byte {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	return bytes.TrimSuffix(b, []byte("\r"))
+}
@@ -0,0 +1,59 @@
+package rlmpeek
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFindAnchors(t *testing.T) {
+	const text = "Contents: ARTICLE VII, ARTICLE VIII\n" +
+		"ARTICLE VII\nseven\n" +
+		"ARTICLE VIII\neight\n"
+	from := regexp.MustCompile(`ARTICLE VII\b`)
+	to := regexp.MustCompile(`ARTICLE VIII`)
+	find := func(opts AnchorOptions) (Anchored, string, error) {
+		a, err := FindAnchors(io.NewSectionReader(strings.NewReader(text), 0, int64(len(text))), opts)
+		if err != nil {
+			return a, "", err
+		}
+		return a, text[a.Start:a.End], nil
+	}
+
+	// The first occurrence is in the table of contents, on the same line
+	// as its end anchor.
+	a, got, err := find(AnchorOptions{From: from, To: to})
+	if err != nil || got != "ARTICLE VII, " || !a.ToFound || a.FromEnd != a.Start+11 {
+		t.Errorf("occurrence 1 = %+v %q, %v", a, got, err)
+	}
+	a, got, err = find(AnchorOptions{From: from, To: to, Occurrence: 2})
+	if err != nil || got != "ARTICLE VII\nseven\n" || !a.ToFound || a.Truncated {
+		t.Errorf("occurrence 2 = %+v %q, %v", a, got, err)
+	}
+	a, got, err = find(AnchorOptions{From: from, To: to, Occurrence: 2, MaxBytes: 5})
+	if err != nil || got != "ARTIC" || a.ToFound || !a.Truncated {
+		t.Errorf("max bytes = %+v %q, %v", a, got, err)
+	}
+	a, got, err = find(AnchorOptions{From: regexp.MustCompile(`eight`), To: to})
+	if err != nil || got != "eight\n" || a.ToFound || a.Truncated {
+		t.Errorf("no end anchor = %+v %q, %v", a, got, err)
+	}
+	a, got, err = find(AnchorOptions{From: regexp.MustCompile(`seven`)})
+	if err != nil || got != "seven\nARTICLE VIII\neight\n" || a.Truncated {
+		t.Errorf("no to regex = %+v %q, %v", a, got, err)
+	}
+	// $ anchors at the end of a line, telling VII from VIII.
+	a, got, err = find(AnchorOptions{From: regexp.MustCompile(`ARTICLE VII$`), To: regexp.MustCompile(`^ARTICLE`)})
+	if err != nil || got != "ARTICLE VII\nseven\n" || !a.ToFound {
+		t.Errorf("end of line anchor = %+v %q, %v", a, got, err)
+	}
+	crlf := "ARTICLE VIII\r\nARTICLE VII\r\nseven\r\n"
+	a, err = FindAnchors(io.NewSectionReader(strings.NewReader(crlf), 0, int64(len(crlf))), AnchorOptions{From: regexp.MustCompile(`VII$`)})
+	if err != nil || a.Start != 22 {
+		t.Errorf("end of CRLF line anchor = %+v, %v", a, err)
+	}
+	if _, _, err := find(AnchorOptions{From: from, Occurrence: 3}); err == nil {
+		t.Error("expected an error for a missing occurrence")
+	}
+}
@@ -0,0 +1,178 @@
+package rlmpeek
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmchunk"
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmindex"
+)
+
+// DefaultBytes is the length of a byte range given without an end.
+const DefaultBytes = 8192
+
+// Request is one range of a batch. With StartLine set it selects lines
+// StartLine through EndLine (0 meaning the last line); otherwise bytes
+// [Start, End), where an End of -1 means EOF and 0 means DefaultBytes.
+type Request struct {
+	Path      string `json:"path"`
+	Start     int64  `json:"start,omitempty"`
+	End       int64  `json:"end,omitempty"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+}
+
+// Result is the text of one Request, or the error that prevented reading
+// it.
+type Result struct {
+	Path      string `json:"path"`
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+	Text      string `json:"text"`
+	// ValidUTF8 is false when Text holds bytes that are not valid UTF-8,
+	// which JSON encoding replaces.
+	ValidUTF8 bool   `json:"valid_utf8"`
+	Error     string `json:"error,omitempty"`
+}
+
+type BatchOptions struct {
+	// Raw keeps byte offsets as given instead of moving them to UTF-8 rune
+	// starts.
+	Raw bool
+	// LinesDir, when set, holds line indexes used (and built or refreshed)
+	// for line requests.
+	LinesDir string
+}
+
+// ReadBatch returns the text of every request, in request order. Each file
+// is opened once, and overlapping or adjacent ranges of a file are read as
+// one region. A request that cannot be read reports its Error without
+// affecting the others.
+func ReadBatch(reqs []Request, opts BatchOptions) []Result {
+	out := make([]Result, len(reqs))
+	byPath := map[string][]int{}
+	var paths []string
+	for i, r := range reqs {
+		if _, ok := byPath[r.Path]; !ok {
+			paths = append(paths, r.Path)
+		}
+		byPath[r.Path] = append(byPath[r.Path], i)
+	}
+	for _, p := range paths {
+		readFile(p, reqs, byPath[p], out, opts)
+	}
+	return out
+}
+
+// readFile fills out[i] for the requests idx of one file.
+func readFile(path string, reqs []Request, idx []int, out []Result, opts BatchOptions) {
+	fail := func(i int, err error) {
+		out[i] = Result{Path: path, Error: err.Error()}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		for _, i := range idx {
+			fail(i, err)
+		}
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		for _, i := range idx {
+			fail(i, err)
+		}
+		return
+	}
+
+	var (
+		lines    *rlmindex.Lines
+		triedIdx bool
+		ok       []int
+	)
+	for _, i := range idx {
+		r := reqs[i]
+		var res Result
+		if r.StartLine > 0 {
+			if !triedIdx && opts.LinesDir != "" {
+				// Without a usable line index, lines are found by streaming.
+				triedIdx = true
+				if lines, _, err = rlmindex.EnsureLines(opts.LinesDir, path); err == nil {
+					defer lines.Close()
+				}
+			}
+			var rg Range
+			if lines != nil {
+				rg, err = IndexedLineRange(lines, r.StartLine, r.EndLine)
+			} else {
+				rg, err = LineRange(io.NewSectionReader(f, 0, st.Size()), r.StartLine, r.EndLine)
+			}
+			res = Result{Start: rg.Start, End: rg.End, StartLine: rg.StartLine, EndLine: rg.EndLine}
+		} else {
+			res.Start, res.End, err = byteRange(f, st.Size(), r, opts.Raw)
+		}
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		res.Path = path
+		out[i] = res
+		ok = append(ok, i)
+	}
+
+	// Read merged regions, then cut each result's text from its region.
+	sort.Slice(ok, func(a, b int) bool { return out[ok[a]].Start < out[ok[b]].Start })
+	for k := 0; k < len(ok); {
+		start, end := out[ok[k]].Start, out[ok[k]].End
+		j := k + 1
+		for ; j < len(ok) && out[ok[j]].Start <= end; j++ {
+			end = max(end, out[ok[j]].End)
+		}
+		buf := make([]byte, end-start)
+		n, err := f.ReadAt(buf, start)
+		if err != nil && err != io.EOF {
+			for _, i := range ok[k:j] {
+				fail(i, err)
+			}
+		} else {
+			buf = buf[:n]
+			for _, i := range ok[k:j] {
+				lo := min(out[i].Start-start, int64(n))
+				hi := min(out[i].End-start, int64(n))
+				out[i].Text = string(buf[lo:hi])
+				out[i].ValidUTF8 = utf8.Valid(buf[lo:hi])
+			}
+		}
+		k = j
+	}
+}
+
+// byteRange resolves a byte request against a file of the given size, as
+// rlm peek --start/--end does.
+func byteRange(f *os.File, size int64, r Request, raw bool) (int64, int64, error) {
+	s, e := max(r.Start, 0), r.End
+	switch {
+	case e < 0:
+		e = size
+	case e == 0:
+		e = s + DefaultBytes
+	case e < s:
+		return 0, 0, fmt.Errorf("end %d is before start %d", e, s)
+	}
+	e = min(e, size)
+	s = min(s, e)
+	if raw {
+		return s, e, nil
+	}
+	s, err := rlmchunk.RuneStart(f, s, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	e, err = rlmchunk.RuneStart(f, e, size)
+	return s, e, err
+}
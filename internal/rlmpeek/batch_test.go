@@ -0,0 +1,55 @@
+package rlmpeek
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadBatch(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	if err := os.WriteFile(a, []byte("l1\nl2\nl3\nl4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("héllo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := []Request{
+		{Path: a, Start: 3, End: 9},
+		{Path: b, Start: 2, End: -1},
+		{Path: a, StartLine: 2, EndLine: 2},
+		{Path: filepath.Join(dir, "missing.txt")},
+		{Path: a, Start: 0, End: 4},
+		{Path: a, StartLine: 9},
+	}
+	for _, linesDir := range []string{"", filepath.Join(dir, "lines")} {
+		got := ReadBatch(reqs, BatchOptions{LinesDir: linesDir})
+		want := []Result{
+			{Path: a, Start: 3, End: 9, Text: "l2\nl3\n", ValidUTF8: true},
+			{Path: b, Start: 1, End: 6, Text: "éllo", ValidUTF8: true},
+			{Path: a, Start: 3, End: 6, StartLine: 2, EndLine: 2, Text: "l2\n", ValidUTF8: true},
+			{Path: reqs[3].Path},
+			{Path: a, Start: 0, End: 4, Text: "l1\nl", ValidUTF8: true},
+			{Path: a},
+		}
+		for i := range want {
+			g := got[i]
+			if (g.Error != "") != (i == 3 || i == 5) {
+				t.Errorf("lines=%q: result %d error = %q", linesDir, i, g.Error)
+			}
+			g.Error = ""
+			if g != want[i] {
+				t.Errorf("lines=%q: result %d = %+v, want %+v", linesDir, i, g, want[i])
+			}
+		}
+	}
+
+	// A raw range that cuts a rune in half is flagged.
+	got := ReadBatch([]Request{{Path: b, Start: 2, End: 3}}, BatchOptions{Raw: true})
+	if got[0].ValidUTF8 || got[0].Text != "\xa9" {
+		t.Errorf("raw split rune = %+v", got[0])
+	}
+}
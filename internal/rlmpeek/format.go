@@ -0,0 +1,38 @@
+package rlmpeek
+
+import (
+	"fmt"
+	"strings"
+)
+
+const hexDumpWidth = 16
+
+// HexDump formats b like xxd: rows of 16 bytes, each starting with the file
+// offset (b being read at off), the bytes in groups of two, then the bytes
+// as ASCII with '.' for anything non-printable.
+func HexDump(b []byte, off int64) string {
+	var sb strings.Builder
+	for i := 0; i < len(b); i += hexDumpWidth {
+		row := b[i:min(i+hexDumpWidth, len(b))]
+		fmt.Fprintf(&sb, "%08x: ", off+int64(i))
+		for j := 0; j < hexDumpWidth; j++ {
+			if j < len(row) {
+				fmt.Fprintf(&sb, "%02x", row[j])
+			} else {
+				sb.WriteString("  ")
+			}
+			if j%2 == 1 {
+				sb.WriteByte(' ')
+			}
+		}
+		sb.WriteByte(' ')
+		for _, c := range row {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			sb.WriteByte(c)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
@@ -0,0 +1,18 @@
+package rlmpeek
+
+import "testing"
+
+func TestHexDump(t *testing.T) {
+	b := []byte("Hello\nWorld\n\x00\x01\xff abcdefghijk")
+	want := "00000000: 4865 6c6c 6f0a 576f 726c 640a 0001 ff20  Hello.World.... \n" +
+		"00000010: 6162 6364 6566 6768 696a 6b              abcdefghijk\n"
+	if got := HexDump(b, 0); got != want {
+		t.Errorf("HexDump =\n%s\nwant\n%s", got, want)
+	}
+	if got, want := HexDump(b[3:8], 3), "00000003: 6c6f 0a57 6f                             lo.Wo\n"; got != want {
+		t.Errorf("HexDump at an offset = %q, want %q", got, want)
+	}
+	if got := HexDump(nil, 0); got != "" {
+		t.Errorf("HexDump(nil) = %q", got)
+	}
+}
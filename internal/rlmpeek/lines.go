@@ -0,0 +1,104 @@
+package rlmpeek
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmindex"
+)
+
+// Range is a byte range of a file with the lines it covers.
+type Range struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	// StartLine and EndLine are 1-based and inclusive.
+	StartLine int `json:"start_line"`
+	EndLine   int `json:"end_line"`
+}
+
+// maxFragmentBytes bounds the memory used for a single line: longer lines
+// are read in fragments, as search does.
+const maxFragmentBytes = 256 * 1024
+
+// LineRange streams r, which starts at line 1, to the byte range of lines
+// from through to (1-based, inclusive). A to past the last line, or <= 0,
+// means the last line. Line ends, including "\r\n", belong to their line.
+// It is an error for from to be past the last line.
+func LineRange(r io.Reader, from, to int) (Range, error) {
+	if from < 1 {
+		return Range{}, fmt.Errorf("line numbers start at 1")
+	}
+	if to > 0 && to < from {
+		return Range{}, fmt.Errorf("end line must be >= start line")
+	}
+
+	reader := bufio.NewReaderSize(r, maxFragmentBytes)
+	rg := Range{StartLine: from}
+	var pos int64
+	line := 1 // line that pos is in
+	partial := false
+	for {
+		if line == from && !partial {
+			rg.Start = pos
+		}
+		frag, err := reader.ReadSlice('\n')
+		pos += int64(len(frag))
+		switch err {
+		case nil:
+			// A line ends here.
+			partial = false
+			if line == to {
+				rg.End, rg.EndLine = pos, line
+				return rg, nil
+			}
+			line++
+			continue
+		case bufio.ErrBufferFull:
+			// Continuation of a very long line.
+			partial = true
+			continue
+		case io.EOF:
+		default:
+			return Range{}, err
+		}
+
+		// EOF: the last line is either unterminated (frag is its tail) or
+		// the one before.
+		last := line
+		if len(frag) == 0 && !partial {
+			last--
+		}
+		if from > last {
+			return Range{}, fmt.Errorf("line %d is past the end of the file (%d lines)", from, last)
+		}
+		rg.End, rg.EndLine = pos, last
+		return rg, nil
+	}
+}
+
+// IndexedLineRange is LineRange answered from the file's line index.
+func IndexedLineRange(l *rlmindex.Lines, from, to int) (Range, error) {
+	if from < 1 {
+		return Range{}, fmt.Errorf("line numbers start at 1")
+	}
+	if to > 0 && to < from {
+		return Range{}, fmt.Errorf("end line must be >= start line")
+	}
+	n := l.Count()
+	if from > n {
+		return Range{}, fmt.Errorf("line %d is past the end of the file (%d lines)", from, n)
+	}
+	if to <= 0 || to > n {
+		to = n
+	}
+	start, err := l.LineStart(from)
+	if err != nil {
+		return Range{}, err
+	}
+	end, err := l.LineStart(to + 1)
+	if err != nil {
+		return Range{}, err
+	}
+	return Range{Start: start, End: end, StartLine: from, EndLine: to}, nil
+}
@@ -0,0 +1,83 @@
+package rlmpeek
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmindex"
+)
+
+func TestLineRange(t *testing.T) {
+	const text = "one\ntwo\r\nthree\n\nfive"
+	tests := []struct {
+		from, to int
+		want     Range
+		wantText string
+	}{
+		{1, 1, Range{0, 4, 1, 1}, "one\n"},
+		{2, 3, Range{4, 15, 2, 3}, "two\r\nthree\n"},
+		{4, 4, Range{15, 16, 4, 4}, "\n"},
+		{4, 0, Range{15, 20, 4, 5}, "\nfive"},
+		{5, 99, Range{16, 20, 5, 5}, "five"},
+	}
+	for _, tt := range tests {
+		got, err := LineRange(strings.NewReader(text), tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("LineRange(%d, %d): %v", tt.from, tt.to, err)
+		}
+		if got != tt.want || text[got.Start:got.End] != tt.wantText {
+			t.Errorf("LineRange(%d, %d) = %+v %q, want %+v %q", tt.from, tt.to, got, text[got.Start:got.End], tt.want, tt.wantText)
+		}
+	}
+
+	if _, err := LineRange(strings.NewReader("a\nb\n"), 3, 3); err == nil {
+		t.Error("expected an error past the last line")
+	}
+	if _, err := LineRange(strings.NewReader("a\nb\n"), 2, 1); err == nil {
+		t.Error("expected an error for a reversed range")
+	}
+}
+
+func TestLineRange_LongLines(t *testing.T) {
+	long := strings.Repeat("x", 3*maxFragmentBytes+5)
+	text := "a\n" + long + "\nb\n" + long
+	got, err := LineRange(strings.NewReader(text), 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Range{Start: 2, End: int64(2 + len(long) + 3), StartLine: 2, EndLine: 3}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	got, err = LineRange(bufio.NewReader(strings.NewReader(text)), 4, 4)
+	if err != nil || got.End != int64(len(text)) || got.EndLine != 4 {
+		t.Errorf("last line = %+v, %v", got, err)
+	}
+}
+
+func TestIndexedLineRange_MatchesLineRange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	const text = "one\ntwo\r\nthree\n\nfive"
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l, _, err := rlmindex.EnsureLines(filepath.Join(dir, "lines"), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for from := 1; from <= 6; from++ {
+		for _, to := range []int{0, from, from + 1, 99} {
+			want, wantErr := LineRange(strings.NewReader(text), from, to)
+			got, err := IndexedLineRange(l, from, to)
+			if (err != nil) != (wantErr != nil) || got != want {
+				t.Errorf("lines %d:%d: got %+v, %v; want %+v, %v", from, to, got, err, want, wantErr)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package rlmsearch
+
+import (
+	"os"
+	"path/filepath"
+	"regexp/syntax"
+
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmindex"
+)
+
+// searchIndex narrows a search to candidate files and blocks using a trigram
+// index. A nil *searchIndex is valid and narrows nothing.
+type searchIndex struct {
+	ix         *rlmindex.Index
+	contextDir string
+	cands      map[int][]int
+	narrow     bool
+}
+
+// openIndex returns nil when no usable index exists for opts.ContextDir. The
+// index is purely an accelerator, so any problem with it falls back to a full
+// scan rather than failing the search.
+func openIndex(opts Options) *searchIndex {
+	if opts.IndexDir == "" {
+		return nil
+	}
+	contextDir, err := filepath.Abs(opts.ContextDir)
+	if err != nil {
+		return nil
+	}
+	ix, err := rlmindex.Open(opts.IndexDir)
+	if err != nil {
+		return nil
+	}
+	if filepath.Clean(ix.ContextDir) != contextDir {
+		_ = ix.Close()
+		return nil
+	}
+
+	s := &searchIndex{ix: ix, contextDir: contextDir}
+	if lit, fold := queryLiteral(opts); lit != "" {
+		cands, ok, err := ix.Candidates(lit, fold)
+		if err != nil {
+			_ = ix.Close()
+			return nil
+		}
+		s.cands, s.narrow = cands, ok
+	}
+	return s
+}
+
+func (s *searchIndex) Close() error {
+	return s.ix.Close()
+}
+
+// ranges returns the byte ranges of path that may contain a match. indexed is
+// false when the file is missing from the index or changed since it was built;
+// the caller must then scan the whole file. An indexed file with no ranges
+// cannot match; binary reports that it was skipped as binary at build time.
+func (s *searchIndex) ranges(path string) (ranges []rlmindex.Block, indexed, binary bool, err error) {
+	if s == nil {
+		return nil, false, false, nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, false, false, err
+	}
+	rel, err := filepath.Rel(s.contextDir, abs)
+	if err != nil {
+		return nil, false, false, nil
+	}
+	id, entry, ok := s.ix.Lookup(filepath.ToSlash(rel))
+	if !ok {
+		return nil, false, false, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false, false, err
+	}
+	if !entry.Fresh(info) {
+		return nil, false, false, nil
+	}
+	if entry.Binary {
+		return nil, true, true, nil
+	}
+	if !s.narrow {
+		return []rlmindex.Block{{Start: 0, End: -1, Line: 1}}, true, false, nil
+	}
+
+	var out []rlmindex.Block
+	for _, b := range s.cands[id] {
+		if b >= len(entry.Blocks) {
+			continue
+		}
+		blk := entry.Blocks[b]
+		if n := len(out); n > 0 && out[n-1].End == blk.Start {
+			out[n-1].End = blk.End
+			continue
+		}
+		out = append(out, blk)
+	}
+	return out, true, false, nil
+}
+
+// withContext widens candidate ranges of path by Options.Before lines before
+// and Options.After lines after, merging ranges that then touch. It returns
+// nil, meaning a full scan, when no line index can be used.
+func (m *matcher) withContext(path string, ranges []rlmindex.Block) []rlmindex.Block {
+	if m.opts.LinesDir == "" {
+		return nil
+	}
+	l, _, err := rlmindex.EnsureLines(m.opts.LinesDir, path)
+	if err != nil {
+		return nil
+	}
+	defer l.Close()
+
+	var out []rlmindex.Block
+	for _, r := range ranges {
+		w := rlmindex.Block{Line: max(1, r.Line-m.opts.Before)}
+		if w.Start, err = l.LineStart(w.Line); err != nil {
+			return nil
+		}
+		// r.End is the start of the line after the range, or the file size.
+		w.End = l.Size()
+		if r.End < l.Size() {
+			next, err := l.LineOf(r.End)
+			if err != nil {
+				return nil
+			}
+			if w.End, err = l.LineStart(min(next+m.opts.After, l.Count()+1)); err != nil {
+				return nil
+			}
+		}
+		if n := len(out); n > 0 && out[n-1].End >= w.Start {
+			out[n-1].End = max(out[n-1].End, w.End)
+			continue
+		}
+		out = append(out, w)
+	}
+	return out
+}
+
+// queryLiteral returns a string every match must contain, and whether it is
+// matched case-insensitively. For regexes this is the longest literal in the
+// top-level concatenation, or "" when there is none.
+func queryLiteral(opts Options) (string, bool) {
+	if !opts.Regex {
+		return opts.Query, opts.IgnoreCase
+	}
+	flags := syntax.Perl
+	if opts.IgnoreCase {
+		flags |= syntax.FoldCase
+	}
+	re, err := syntax.Parse(opts.Query, flags)
+	if err != nil {
+		return "", false
+	}
+	re = re.Simplify()
+	for re.Op == syntax.OpCapture {
+		re = re.Sub[0]
+	}
+
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	best, fold := "", false
+	for _, sub := range subs {
+		if sub.Op != syntax.OpLiteral {
+			continue
+		}
+		if lit := string(sub.Rune); len(lit) > len(best) {
+			best, fold = lit, sub.Flags&syntax.FoldCase != 0
+		}
+	}
+	return best, fold
+}
@@ -2,12 +2,21 @@ package rlmsearch
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"math"
 	"os"
-	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmfilter"
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmindex"
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmwalk"
 )
 
 type Options struct {
@@ -18,29 +27,104 @@ type Options struct {
 	MaxMatches   int
 	MaxPerFile   int
 	MaxLineChars int
+	// IndexDir, when set, points at a trigram index built by rlmindex.Build.
+	// Files it covers are only scanned within candidate blocks; files that are
+	// new or changed since the build are scanned in full.
+	IndexDir string
+	// LinesDir, when set, holds line indexes (rlmindex.BuildLines). With
+	// context lines, files narrowed by the trigram index are then scanned
+	// only around their candidate blocks instead of in full; missing or
+	// stale line indexes are rebuilt as needed.
+	LinesDir string
+	// Workers is the number of files searched concurrently; <= 0 uses one
+	// worker per CPU. Results are ordered as if searched sequentially.
+	Workers int
+	// Before and After request that many lines of context around each match.
+	Before int
+	After  int
+	// AllOccurrences reports every match on a line instead of the first.
+	// MaxMatches and MaxPerFile then count occurrences.
+	AllOccurrences bool
+	// Filter restricts which files are searched.
+	Filter rlmfilter.Filter
+	// CountAll keeps scanning after the limits are reached to report the
+	// true number of matches in Summary.TotalMatches.
+	CountAll bool
 }
 
 type Match struct {
-	Path    string `json:"path"`
-	Line    int    `json:"line"`
-	Column  int    `json:"column"`
-	Snippet string `json:"snippet"`
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	// ByteOffset and ByteEnd delimit the match within the file, suitable
+	// for rlm peek --start/--end or --around.
+	ByteOffset int64  `json:"byte_offset"`
+	ByteEnd    int64  `json:"byte_end"`
+	Snippet    string `json:"snippet"`
+	// ContextBefore and ContextAfter hold up to Options.Before/After lines
+	// around the match, never repeating lines already reported for an
+	// earlier match in the same file.
+	ContextBefore []string `json:"context_before,omitempty"`
+	ContextAfter  []string `json:"context_after,omitempty"`
+}
+
+// Summary describes a completed search, independent of how its matches were
+// delivered.
+type Summary struct {
+	Query      string `json:"query"`
+	ContextDir string `json:"context_dir"`
+	Files      int    `json:"scanned_files"`
+	// IndexedFiles counts scanned files answered with help of the index.
+	IndexedFiles int   `json:"indexed_files,omitempty"`
+	DurationMs   int64 `json:"duration_ms"`
+	// Truncated is set when matches exist beyond those reported, because
+	// one of the limits in LimitsHit ("max_matches", "max_per_file") applied.
+	Truncated bool     `json:"truncated"`
+	LimitsHit []string `json:"limits_hit,omitempty"`
+	// CappedFiles lists files that had more than MaxPerFile matches.
+	CappedFiles   []CappedFile `json:"capped_files,omitempty"`
+	BinarySkipped int          `json:"binary_skipped"`
+	// TotalMatches is the true number of matches, only computed with
+	// Options.CountAll; nil otherwise, so that a count of 0 is still
+	// reported.
+	TotalMatches *int `json:"total_matches,omitempty"`
+}
+
+type CappedFile struct {
+	Path     string `json:"path"`
+	Reported int    `json:"reported"`
+	// Total is only known with Options.CountAll.
+	Total int `json:"total,omitempty"`
 }
 
 type Result struct {
-	Query      string  `json:"query"`
-	ContextDir string  `json:"context_dir"`
-	Matches    []Match `json:"matches"`
-	Files      int     `json:"scanned_files"`
-	DurationMs int64   `json:"duration_ms"`
+	Summary
+	Matches []Match `json:"matches"`
 }
 
+// SearchDir runs Search and collects every match.
 func SearchDir(opts Options) (Result, error) {
+	var res Result
+	sum, err := Search(opts, func(m Match) error {
+		res.Matches = append(res.Matches, m)
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	res.Summary = sum
+	return res, nil
+}
+
+// Search calls fn for each match as soon as it is final, in path/line order.
+// An error from fn aborts the search and is returned.
+func Search(opts Options, fn func(Match) error) (Summary, error) {
+	start := time.Now()
 	if opts.ContextDir == "" {
-		return Result{}, fmt.Errorf("context_dir is required")
+		return Summary{}, fmt.Errorf("context_dir is required")
 	}
 	if opts.Query == "" {
-		return Result{}, fmt.Errorf("query is required")
+		return Summary{}, fmt.Errorf("query is required")
 	}
 	if opts.MaxMatches <= 0 {
 		opts.MaxMatches = 50
@@ -51,8 +135,11 @@ func SearchDir(opts Options) (Result, error) {
 	if opts.MaxLineChars <= 0 {
 		opts.MaxLineChars = 800
 	}
+	if err := opts.Filter.Validate(); err != nil {
+		return Summary{}, err
+	}
 
-	var re *regexp.Regexp
+	var re, fold *regexp.Regexp
 	var err error
 	qFixed := opts.Query
 	if opts.Regex {
@@ -62,130 +149,493 @@ func SearchDir(opts Options) (Result, error) {
 		}
 		re, err = regexp.Compile(pattern)
 		if err != nil {
-			return Result{}, err
+			return Summary{}, err
 		}
 	} else {
 		if opts.IgnoreCase {
 			qFixed = strings.ToLower(qFixed)
+			fold = regexp.MustCompile("(?i)" + regexp.QuoteMeta(opts.Query))
 		}
 	}
 
-	res := Result{Query: opts.Query, ContextDir: opts.ContextDir}
+	files, err := walkFiles(opts.ContextDir, opts.Filter)
+	if err != nil {
+		return Summary{}, err
+	}
 
-	skipDirs := map[string]bool{
-		".git":         true,
-		".rlm":         true,
-		"node_modules": true,
+	m := &matcher{opts: opts, re: re, qFixed: qFixed, fold: fold, ix: openIndex(opts)}
+	if m.ix != nil {
+		defer m.ix.Close()
 	}
 
-	err = filepath.WalkDir(opts.ContextDir, func(path string, d os.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
+	sum := Summary{Query: opts.Query, ContextDir: opts.ContextDir}
+	// Once MaxMatches have been reported the search keeps going only until
+	// one more match shows up, which proves the result is truncated, unless
+	// CountAll asks for every remaining match to be counted.
+	found, fromFile, total := 0, 0, 0
+	hitMax, hitPerFile := false, false
+	err = m.run(files, func(mt Match) (bool, error) {
+		if found >= opts.MaxMatches {
+			hitMax = true
+			return opts.CountAll, nil
 		}
-		if d.IsDir() {
-			name := d.Name()
-			if strings.HasPrefix(name, ".") {
-				return filepath.SkipDir
-			}
-			if skipDirs[name] {
-				return filepath.SkipDir
+		found++
+		fromFile++
+		if err := fn(mt); err != nil {
+			return false, err
+		}
+		return true, nil
+	}, func(fr fileResult) bool {
+		sum.Files++
+		if fr.indexed {
+			sum.IndexedFiles++
+		}
+		if fr.binary {
+			sum.BinarySkipped++
+		}
+		total += fr.total
+		if fr.total > opts.MaxPerFile {
+			hitPerFile = true
+			cf := CappedFile{Path: fr.path, Reported: fromFile}
+			if opts.CountAll {
+				cf.Total = fr.total
 			}
-			return nil
+			sum.CappedFiles = append(sum.CappedFiles, cf)
 		}
-		// Skip hidden files.
-		if strings.HasPrefix(d.Name(), ".") {
-			return nil
+		if found >= opts.MaxMatches && fromFile < min(fr.total, opts.MaxPerFile) {
+			hitMax = true
 		}
-		res.Files++
+		fromFile = 0
+		return !hitMax || opts.CountAll
+	})
+	if err != nil {
+		return Summary{}, err
+	}
+	if opts.CountAll {
+		sum.TotalMatches = &total
+	}
+	if hitMax {
+		sum.LimitsHit = append(sum.LimitsHit, "max_matches")
+	}
+	if hitPerFile {
+		sum.LimitsHit = append(sum.LimitsHit, "max_per_file")
+	}
+	sum.Truncated = hitMax || hitPerFile
+
+	sum.DurationMs = time.Since(start).Milliseconds()
+	return sum, nil
+}
+
+func walkFiles(contextDir string, filter rlmfilter.Filter) ([]string, error) {
+	var files []string
+	err := rlmwalk.Walk(contextDir, filter, func(e rlmwalk.Entry) error {
+		files = append(files, e.Path)
+		return nil
+	})
+	return files, err
+}
+
+type matcher struct {
+	opts   Options
+	re     *regexp.Regexp
+	qFixed string
+	fold   *regexp.Regexp // case-insensitive fixed query, for non-ASCII lines
+	ix     *searchIndex
+}
 
-		matchesInFile := 0
-		f, err := os.Open(path)
+type fileResult struct {
+	path    string
+	indexed bool
+	binary  bool
+	// total counts matches found in the file. Without CountAll the scan
+	// stops one match past the per-file limit, so total > limit only tells
+	// that the file was capped.
+	total int
+	err   error
+}
+
+// fileStream carries one file's matches to the merge as soon as they are
+// final, followed by the file's result.
+type fileStream struct {
+	path    string
+	matches chan Match
+	done    chan fileResult
+}
+
+// run searches files on a pool of opts.Workers goroutines and hands matches
+// to onMatch strictly in file order, so output is identical to a sequential
+// scan, while still streaming matches of the file at the head of the queue.
+// onFile is called after each file's matches. Once onMatch or onFile returns
+// false, or onMatch an error, in-flight workers are cancelled and no further
+// files are started.
+func (m *matcher) run(files []string, onMatch func(Match) (bool, error), onFile func(fileResult) bool) error {
+	workers := m.opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	workers = min(workers, max(len(files), 1))
+	limit := min(m.opts.MaxPerFile, m.opts.MaxMatches)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// order hands streams to the merge in file order; its capacity bounds
+	// how far workers may run ahead of the merge, and with it memory use.
+	order := make(chan fileStream, workers*4)
+	jobs := make(chan fileStream)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(order)
+		for _, path := range files {
+			st := fileStream{
+				path:    path,
+				matches: make(chan Match, min(limit, 256)),
+				done:    make(chan fileResult, 1),
+			}
+			select {
+			case order <- st:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- st:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for st := range jobs {
+				fr := m.searchFile(ctx, st.path, st.matches)
+				close(st.matches)
+				st.done <- fr
+			}
+		}()
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for st := range order {
+		more := true
+		var err error
+		for mt := range st.matches {
+			if more, err = onMatch(mt); err != nil || !more {
+				break
+			}
+		}
+		if err != nil || !more {
+			// Unblock the worker still producing this file.
+			cancel()
+		}
+		fr := <-st.done
 		if err != nil {
 			return err
 		}
-		// Binary detection: if there are NUL bytes in the first chunk, skip.
-		if isLikelyBinary(f) {
-			_ = f.Close()
+		if fr.err != nil {
+			return fr.err
+		}
+		if !onFile(fr) || !more {
 			return nil
 		}
-		if _, err := f.Seek(0, io.SeekStart); err != nil {
-			_ = f.Close()
-			return err
+	}
+	return nil
+}
+
+func (m *matcher) searchFile(ctx context.Context, path string, out chan<- Match) fileResult {
+	if ctx.Err() != nil {
+		return fileResult{}
+	}
+	ranges, indexed, binary, err := m.ix.ranges(path)
+	if err != nil {
+		return fileResult{err: err}
+	}
+	fr := fileResult{path: path, indexed: indexed, binary: binary}
+	if indexed && len(ranges) == 0 {
+		return fr
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fileResult{err: err}
+	}
+	defer f.Close()
+	// Binary detection: if there are NUL bytes in the first chunk, skip.
+	// Indexed files were already classified when the index was built.
+	if !indexed && isLikelyBinary(f) {
+		fr.binary = true
+		return fr
+	}
+	// Context lines may lie outside the candidate blocks, so a narrowed file
+	// is widened by the context lines, or scanned in full without a line
+	// index.
+	if ranges != nil && (m.opts.Before > 0 || m.opts.After > 0) {
+		ranges = m.withContext(path, ranges)
+	}
+	if ranges == nil {
+		ranges = []rlmindex.Block{{Start: 0, End: -1, Line: 1}}
+	}
+
+	// A single file can never contribute more than the global cap. One
+	// extra match is looked for to tell whether the file was capped.
+	fs := &fileScan{ctx: ctx, out: out, limit: min(m.opts.MaxPerFile, m.opts.MaxMatches)}
+	fs.stopAt = fs.limit + 1
+	if m.opts.CountAll {
+		fs.stopAt = math.MaxInt
+	}
+	for _, r := range ranges {
+		if fs.total >= fs.stopAt {
+			break
+		}
+		if err := m.scan(fs, f, path, r); err != nil {
+			return fileResult{err: err}
 		}
+		fs.publish(len(fs.matches))
+	}
+	fr.total = fs.total
+	return fr
+}
 
-		reader := bufio.NewReaderSize(f, 256*1024)
-		lineNo := 0
-		colBase := 0
-		const maxFragmentBytes = 256 * 1024
-		for {
-			if len(res.Matches) >= opts.MaxMatches {
-				_ = f.Close()
-				return filepath.SkipAll
-			}
-			if matchesInFile >= opts.MaxPerFile {
-				break
-			}
+// fileScan accumulates the matches of one file and publishes them once they
+// are final, i.e. no further context lines can be attached to them.
+type fileScan struct {
+	ctx     context.Context
+	out     chan<- Match
+	limit   int // matches kept and published
+	stopAt  int // matches counted before the scan stops
+	total   int
+	matches []Match
+	sent    int
+}
 
-			frag, gotNL, readErr := readLineFragment(reader, maxFragmentBytes)
-			if readErr != nil {
-				_ = f.Close()
-				return readErr
-			}
-			if len(frag) == 0 && !gotNL {
-				break
-			}
+func (fs *fileScan) publish(n int) {
+	for ; fs.sent < n; fs.sent++ {
+		select {
+		case fs.out <- fs.matches[fs.sent]:
+		case <-fs.ctx.Done():
+			return
+		}
+	}
+}
 
-			// If we're at the start of a new line, allocate a new line number.
-			if colBase == 0 {
-				lineNo++
-			}
-			base := colBase
+// scan searches the line-aligned byte range r of f; an End of -1 means EOF.
+func (m *matcher) scan(fs *fileScan, f *os.File, path string, r rlmindex.Block) error {
+	opts := m.opts
+	matches := &fs.matches
+	if _, err := f.Seek(r.Start, io.SeekStart); err != nil {
+		return err
+	}
+	var src io.Reader = f
+	if r.End >= 0 {
+		src = io.LimitReader(f, r.End-r.Start)
+	}
+
+	// The reader buffer is exactly maxFragmentBytes, so readLineFragment never
+	// truncates and pos tracks the absolute file offset of every fragment,
+	// including CRLF terminators and continuations of very long lines.
+	const maxFragmentBytes = 256 * 1024
+	reader := bufio.NewReaderSize(src, maxFragmentBytes)
+	lineNo := r.Line - 1
+	colBase := 0
+	pos := r.Start
+	cw := contextWindow{before: opts.Before, after: opts.After}
+	// Context is tracked per logical line: a line split into several
+	// fragments is handed to cw once, after its last fragment, unless one of
+	// its fragments matched.
+	var lineText string
+	lineMatched := false
+	endLine := func() {
+		if !lineMatched {
+			cw.line(matches, lineNo, lineText)
+		}
+	}
+	done := fs.ctx.Done()
+	for {
+		fs.publish(cw.final(len(*matches)))
+		counting := fs.total < fs.stopAt
+		if !counting && !cw.wantsAfter() {
+			return nil
+		}
+		select {
+		case <-done:
+			return nil
+		default:
+		}
 
-			line := string(frag)
-			check := line
-			if opts.IgnoreCase && !opts.Regex {
-				check = strings.ToLower(check)
+		frag, gotNL, readErr := readLineFragment(reader, maxFragmentBytes)
+		if readErr != nil {
+			return readErr
+		}
+		if len(frag) == 0 && !gotNL {
+			if colBase > 0 {
+				endLine()
 			}
+			return nil
+		}
 
-			if opts.Regex {
-				loc := re.FindStringIndex(line)
-				if loc != nil {
-					matchesInFile++
-					res.Matches = append(res.Matches, Match{
-						Path:    path,
-						Line:    lineNo,
-						Column:  base + loc[0] + 1,
-						Snippet: trimLine(line, opts.MaxLineChars),
-					})
-				}
-			} else {
-				idx := strings.Index(check, qFixed)
-				if idx >= 0 {
-					matchesInFile++
-					res.Matches = append(res.Matches, Match{
-						Path:    path,
-						Line:    lineNo,
-						Column:  base + idx + 1,
-						Snippet: trimLine(line, opts.MaxLineChars),
-					})
+		// If we're at the start of a new line, allocate a new line number.
+		if colBase == 0 {
+			lineNo++
+			lineText = trimLine(string(frag), opts.MaxLineChars)
+			lineMatched = false
+		}
+		base := colBase
+		fragStart := pos
+		pos += int64(len(frag))
+
+		line := string(frag)
+		if counting {
+			n := 1
+			if opts.AllOccurrences {
+				n = fs.stopAt - fs.total
+			}
+			for _, loc := range m.find(line, n) {
+				fs.total++
+				// Matches past the limit are only counted; their lines
+				// may still serve as context.
+				if len(*matches) >= fs.limit {
+					continue
 				}
+				*matches = append(*matches, Match{
+					Path:       path,
+					Line:       lineNo,
+					Column:     base + loc[0] + 1,
+					ByteOffset: fragStart + int64(loc[0]),
+					ByteEnd:    fragStart + int64(loc[1]),
+					Snippet:    trimLine(line, opts.MaxLineChars),
+				})
+				cw.match(matches, lineNo)
+				lineMatched = true
 			}
+		}
 
-			if gotNL {
-				colBase = 0
-			} else {
-				// Continuation of a very long line.
-				colBase += len(frag)
-			}
+		if gotNL {
+			endLine()
+			colBase = 0
+		} else {
+			// Continuation of a very long line.
+			colBase += len(frag)
 		}
-		_ = f.Close()
-		return nil
-	})
-	if err != nil {
-		return Result{}, err
 	}
+}
 
-	return res, nil
+// find returns the byte ranges of up to n non-overlapping matches in line.
+func (m *matcher) find(line string, n int) [][]int {
+	if m.opts.Regex {
+		return m.re.FindAllStringIndex(line, n)
+	}
+	check := line
+	if m.opts.IgnoreCase {
+		// Lowercasing non-ASCII text can change its byte length, which would
+		// skew offsets, so only ASCII lines take the fast path.
+		if !isASCII(line) {
+			return m.fold.FindAllStringIndex(line, n)
+		}
+		check = strings.ToLower(check)
+	}
+	var out [][]int
+	for from := 0; len(out) < n; {
+		idx := strings.Index(check[from:], m.qFixed)
+		if idx < 0 {
+			break
+		}
+		start := from + idx
+		out = append(out, []int{start, start + len(m.qFixed)})
+		from = start + len(m.qFixed)
+	}
+	return out
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// contextWindow attaches surrounding lines to matches within one file. Like
+// grep, windows of nearby matches never overlap: a line is reported at most
+// once, either as a match or as context of a single match. Very long lines
+// that are read in several fragments contribute their first fragment only.
+type contextWindow struct {
+	before, after int
+
+	recent      []ctxLine // up to before lines preceding the current line
+	lastShown   int       // last line number reported as match or context
+	pending     []int     // indexes of matches on the last match line
+	pendingLine int
+	afterLeft   int
+}
+
+type ctxLine struct {
+	no   int
+	text string
+}
+
+func (cw *contextWindow) wantsAfter() bool {
+	return cw.afterLeft > 0
+}
+
+// final returns how many of the n matches so far can no longer change.
+func (cw *contextWindow) final(n int) int {
+	if cw.afterLeft > 0 && len(cw.pending) > 0 {
+		return cw.pending[0]
+	}
+	return n
+}
+
+// line records a non-matching line, either as after-context of the pending
+// matches or as a candidate for before-context of the next match.
+func (cw *contextWindow) line(matches *[]Match, no int, text string) {
+	if cw.afterLeft > 0 {
+		for _, i := range cw.pending {
+			(*matches)[i].ContextAfter = append((*matches)[i].ContextAfter, text)
+		}
+		cw.afterLeft--
+		cw.lastShown = no
+		return
+	}
+	if cw.before > 0 {
+		if len(cw.recent) == cw.before {
+			cw.recent = append(cw.recent[:0], cw.recent[1:]...)
+		}
+		cw.recent = append(cw.recent, ctxLine{no: no, text: text})
+	}
+}
+
+// match attaches context to the match just appended to matches. Matches that
+// share a line share its context.
+func (cw *contextWindow) match(matches *[]Match, no int) {
+	if cw.before == 0 && cw.after == 0 {
+		return
+	}
+	i := len(*matches) - 1
+	if no == cw.pendingLine && len(cw.pending) > 0 {
+		first := (*matches)[cw.pending[0]]
+		(*matches)[i].ContextBefore = first.ContextBefore
+		cw.pending = append(cw.pending, i)
+		return
+	}
+
+	for _, l := range cw.recent {
+		if l.no > cw.lastShown && l.no < no {
+			(*matches)[i].ContextBefore = append((*matches)[i].ContextBefore, l.text)
+		}
+	}
+	cw.recent = cw.recent[:0]
+	cw.pending = append(cw.pending[:0], i)
+	cw.pendingLine = no
+	cw.afterLeft = cw.after
+	cw.lastShown = no
 }
 
 func isLikelyBinary(f *os.File) bool {
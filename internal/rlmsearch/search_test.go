@@ -1,9 +1,16 @@
 package rlmsearch
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmindex"
 )
 
 func TestSearchDir_FixedString(t *testing.T) {
@@ -40,3 +47,254 @@ func TestSearchDir_SkipsBinary(t *testing.T) {
 		t.Fatalf("expected 0 matches (binary skipped), got %d", len(res.Matches))
 	}
 }
+
+func TestSearchDir_IndexRescansStaleFiles(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(p, []byte("alpha\nbeta\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idxDir := filepath.Join(t.TempDir(), "index")
+	if _, err := rlmindex.Build(rlmindex.Options{ContextDir: dir, IndexDir: idxDir, BlockSize: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := SearchDir(Options{ContextDir: dir, Query: "beta", IndexDir: idxDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Matches) != 1 || res.Matches[0].Line != 2 || res.IndexedFiles != 1 {
+		t.Fatalf("unexpected indexed result: %+v", res)
+	}
+
+	// Appending changes size and mtime, so the index entry is ignored.
+	if err := os.WriteFile(p, []byte("alpha\nbeta\ngamma\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	res, err = SearchDir(Options{ContextDir: dir, Query: "gamma", IndexDir: idxDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Matches) != 1 || res.Matches[0].Line != 3 || res.IndexedFiles != 0 {
+		t.Fatalf("stale file was not rescanned: %+v", res)
+	}
+}
+
+func TestSearchDir_ParallelMatchesSequentialOrder(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 40; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("f%02d.txt", i))
+		if err := os.WriteFile(p, []byte("x hit\ny\nhit again\nhit thrice\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seq, err := SearchDir(Options{ContextDir: dir, Query: "hit", MaxMatches: 25, MaxPerFile: 2, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	par, err := SearchDir(Options{ContextDir: dir, Query: "hit", MaxMatches: 25, MaxPerFile: 2, Workers: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(par.Matches) != 25 {
+		t.Fatalf("expected exactly 25 matches, got %d", len(par.Matches))
+	}
+	if !reflect.DeepEqual(seq.Matches, par.Matches) || seq.Files != par.Files {
+		t.Fatalf("parallel result differs from sequential:\nseq=%+v\npar=%+v", seq, par)
+	}
+}
+
+func TestSearchDir_ContextWindowsDoNotOverlap(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "a.txt")
+	content := "l1\nl2\nhit3\nl4\nhit5\nl6\nl7\nl8\n"
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := SearchDir(Options{ContextDir: dir, Query: "hit", Before: 2, After: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Matches) != 2 {
+		t.Fatalf("expected 2 matches, got %d", len(res.Matches))
+	}
+	first, second := res.Matches[0], res.Matches[1]
+	if !reflect.DeepEqual(first.ContextBefore, []string{"l1", "l2"}) {
+		t.Fatalf("first before: %q", first.ContextBefore)
+	}
+	if !reflect.DeepEqual(first.ContextAfter, []string{"l4"}) {
+		t.Fatalf("first after should stop at the next match: %q", first.ContextAfter)
+	}
+	if len(second.ContextBefore) != 0 {
+		t.Fatalf("second before should not repeat l4: %q", second.ContextBefore)
+	}
+	if !reflect.DeepEqual(second.ContextAfter, []string{"l6", "l7"}) {
+		t.Fatalf("second after: %q", second.ContextAfter)
+	}
+}
+
+func TestSearchDir_ByteOffsets(t *testing.T) {
+	dir := t.TempDir()
+	content := "ab\r\nÉté Needle\r\nneedle\n"
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := SearchDir(Options{ContextDir: dir, Query: "NEEDLE", IgnoreCase: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Matches) != 2 {
+		t.Fatalf("expected 2 matches, got %d", len(res.Matches))
+	}
+	for _, m := range res.Matches {
+		if got := content[m.ByteOffset:m.ByteEnd]; !strings.EqualFold(got, "needle") {
+			t.Fatalf("line %d: offsets [%d,%d) select %q", m.Line, m.ByteOffset, m.ByteEnd, got)
+		}
+	}
+}
+
+func TestSearchDir_AllOccurrences(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.json"), []byte(`[{"id":1},{"id":2},{"id":3}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := SearchDir(Options{ContextDir: dir, Query: `"id"`, AllOccurrences: true, MaxPerFile: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Matches) != 2 {
+		t.Fatalf("expected per-file cap of 2 occurrences, got %d", len(res.Matches))
+	}
+	if res.Matches[0].Column != 3 || res.Matches[1].Column != 12 {
+		t.Fatalf("unexpected columns %d, %d", res.Matches[0].Column, res.Matches[1].Column)
+	}
+	if res.Matches[1].ByteOffset != 11 {
+		t.Fatalf("unexpected byte offset %d", res.Matches[1].ByteOffset)
+	}
+}
+
+func TestSearch_CallbackErrorStopsSearch(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 10; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("f%d.txt", i))
+		if err := os.WriteFile(p, []byte("hit\nhit\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	_, err := Search(Options{ContextDir: dir, Query: "hit", Workers: 4}, func(m Match) error {
+		calls++
+		if calls == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("callback invoked %d times after returning an error", calls)
+	}
+}
+
+func TestSearchDir_TruncationMetadata(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hit\nhit\nhit\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("hit\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := SearchDir(Options{ContextDir: dir, Query: "hit", MaxMatches: 3, MaxPerFile: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Truncated || !reflect.DeepEqual(res.LimitsHit, []string{"max_per_file"}) {
+		t.Fatalf("expected per-file truncation only, got truncated=%v limits=%v", res.Truncated, res.LimitsHit)
+	}
+	if len(res.CappedFiles) != 1 || res.CappedFiles[0].Reported != 2 {
+		t.Fatalf("unexpected capped files %+v", res.CappedFiles)
+	}
+
+	res, err = SearchDir(Options{ContextDir: dir, Query: "hit", MaxMatches: 2, MaxPerFile: 5, CountAll: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.LimitsHit, []string{"max_matches"}) || res.TotalMatches == nil || *res.TotalMatches != 4 || len(res.Matches) != 2 {
+		t.Fatalf("unexpected count-all result: limits=%v total=%v matches=%d", res.LimitsHit, res.TotalMatches, len(res.Matches))
+	}
+
+	// A counted total of zero is reported, unlike no count at all.
+	res, err = SearchDir(Options{ContextDir: dir, Query: "zzzz", MaxMatches: 2, MaxPerFile: 5, CountAll: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(res.Summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TotalMatches == nil || *res.TotalMatches != 0 || !strings.Contains(string(b), `"total_matches":0`) {
+		t.Fatalf("zero count-all total not reported: %s", b)
+	}
+	res, err = SearchDir(Options{ContextDir: dir, Query: "hit", MaxMatches: 2, MaxPerFile: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TotalMatches != nil {
+		t.Fatalf("total reported without CountAll: %d", *res.TotalMatches)
+	}
+
+	res, err = SearchDir(Options{ContextDir: dir, Query: "hit", MaxMatches: 4, MaxPerFile: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Truncated {
+		t.Fatalf("exactly MaxMatches results must not be reported as truncated: %v", res.LimitsHit)
+	}
+}
+
+func TestSearchDir_ContextWithLineIndex(t *testing.T) {
+	dir := t.TempDir()
+	var b strings.Builder
+	for i := 1; i <= 300; i++ {
+		if i%97 == 0 || i == 299 {
+			fmt.Fprintf(&b, "line %d has the needle\n", i)
+		} else {
+			fmt.Fprintf(&b, "line %d is filler\n", i)
+		}
+	}
+	b.WriteString("last line without newline")
+	p := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(p, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idxDir := filepath.Join(t.TempDir(), "index")
+	if _, err := rlmindex.Build(rlmindex.Options{ContextDir: dir, IndexDir: idxDir, BlockSize: 128}); err != nil {
+		t.Fatal(err)
+	}
+	linesDir := filepath.Join(t.TempDir(), "lines")
+
+	opts := Options{ContextDir: dir, Query: "needle", Before: 3, After: 3}
+	want, err := SearchDir(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.IndexDir, opts.LinesDir = idxDir, linesDir
+	got, err := SearchDir(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Matches) != 4 || !reflect.DeepEqual(got.Matches, want.Matches) {
+		t.Fatalf("narrowed context search differs:\ngot  %+v\nwant %+v", got.Matches, want.Matches)
+	}
+	if _, err := os.Stat(rlmindex.LinesPath(linesDir, p)); err != nil {
+		t.Fatalf("line index was not built: %v", err)
+	}
+}
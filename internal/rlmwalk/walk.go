@@ -0,0 +1,186 @@
+package rlmwalk
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmfilter"
+)
+
+// IgnoreFile is read from every directory of the tree. It uses gitignore
+// syntax; patterns are relative to the directory containing the file, and
+// rules in deeper files take precedence over those above them.
+const IgnoreFile = ".rlmignore"
+
+// Entry is a file in scope of a walk.
+type Entry struct {
+	// Path is root joined with Rel, in the host's path syntax.
+	Path string
+	// Rel is slash-separated and relative to the walk root.
+	Rel  string
+	Info fs.FileInfo
+}
+
+// Walk calls fn, in lexical order, for every file under root that is in
+// scope: dot files and directories, node_modules and anything matched by an
+// .rlmignore rule are skipped, then filter is applied. Returning
+// filepath.SkipAll from fn stops the walk without error.
+func Walk(root string, filter rlmfilter.Filter, fn func(Entry) error) error {
+	if _, err := os.Stat(root); err != nil {
+		return err
+	}
+	err := walkDir(root, "", filter, nil, fn)
+	if err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkDir(dir, rel string, filter rlmfilter.Filter, rules []ruleSet, fn func(Entry) error) error {
+	rs, err := readIgnoreFile(filepath.Join(dir, IgnoreFile), rel)
+	if err != nil {
+		return err
+	}
+	if len(rs.rules) > 0 {
+		rules = append(rules[:len(rules):len(rules)], rs)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range entries {
+		name := d.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		childRel := name
+		if rel != "" {
+			childRel = rel + "/" + name
+		}
+		childPath := filepath.Join(dir, name)
+
+		if d.IsDir() {
+			if name == "node_modules" || ignored(rules, childRel, true) || filter.SkipDir(childRel) {
+				continue
+			}
+			if err := walkDir(childPath, childRel, filter, rules, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if ignored(rules, childRel, false) {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !filter.Match(childRel, info.Size()) {
+			continue
+		}
+		if err := fn(Entry{Path: childPath, Rel: childRel, Info: info}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ruleSet holds the rules of one ignore file, whose directory is base
+// (relative to the walk root, "" for the root itself).
+type ruleSet struct {
+	base  string
+	rules []rule
+}
+
+type rule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func readIgnoreFile(p, base string) (ruleSet, error) {
+	rs := ruleSet{base: base}
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rs, nil
+		}
+		return rs, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if r, ok := parseRule(sc.Text()); ok {
+			rs.rules = append(rs.rules, r)
+		}
+	}
+	return rs, sc.Err()
+}
+
+func parseRule(line string) (rule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule{}, false
+	}
+
+	var r rule
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	// A slash anywhere but at the end anchors the pattern to the ignore
+	// file's directory; otherwise it matches at any depth below it.
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return rule{}, false
+	}
+	r.pattern = line
+	return r, true
+}
+
+// ignored applies rules outermost first; the last matching rule decides.
+func ignored(rules []ruleSet, rel string, isDir bool) bool {
+	out := false
+	for _, rs := range rules {
+		sub := rel
+		if rs.base != "" {
+			sub = strings.TrimPrefix(rel, rs.base+"/")
+		}
+		for _, r := range rs.rules {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			if r.match(sub) {
+				out = !r.negate
+			}
+		}
+	}
+	return out
+}
+
+func (r rule) match(rel string) bool {
+	if r.anchored {
+		return rlmfilter.Match(r.pattern, rel)
+	}
+	return rlmfilter.Match(r.pattern, path.Base(rel))
+}
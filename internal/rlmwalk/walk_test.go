@@ -0,0 +1,49 @@
+package rlmwalk
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Brainqub3/claude_code_RLM/internal/rlmfilter"
+)
+
+func TestWalk_RlmignoreNestedAndNegated(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".rlmignore":           "*.log\narchive/\n",
+		"a.txt":                "",
+		"debug.log":            "",
+		"archive/old.txt":      "",
+		"sub/.rlmignore":       "!keep.log\n/local.txt\n",
+		"sub/keep.log":         "",
+		"sub/drop.log":         "",
+		"sub/local.txt":        "",
+		"sub/deeper/local.txt": "",
+		"node_modules/x.txt":   "",
+		".hidden/y.txt":        "",
+	}
+	for rel, body := range files {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	err := Walk(root, rlmfilter.Filter{}, func(e Entry) error {
+		got = append(got, e.Rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.txt", "sub/deeper/local.txt", "sub/keep.log"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}